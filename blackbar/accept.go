@@ -0,0 +1,75 @@
+package blackbar
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// negotiateFormat picks the format img serves the image stored as ctype
+// in when the request r names none, by the types r's Accept header
+// lists and their q-values. Of the types the client likes best, one it
+// names outright wins over one it accepts through a wildcard; any tie
+// goes to the stored type, then AVIF, WebP, PNG and JPEG in that order,
+// among those this build can write. It returns "" if the header is
+// missing or the stored type will do.
+func negotiateFormat(r *http.Request, ctype string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return ""
+	}
+	candidates := []string{ctype}
+	if encodeAVIF != nil {
+		candidates = append(candidates, "image/avif")
+	}
+	if encodeWebP != nil {
+		candidates = append(candidates, "image/webp")
+	}
+	candidates = append(candidates, "image/png", "image/jpeg")
+
+	best, bestQ, bestSpec := "", 0.0, -1
+	for _, c := range candidates {
+		q, spec := acceptQ(accept, c)
+		if q > bestQ || q == bestQ && q > 0 && spec > bestSpec {
+			best, bestQ, bestSpec = c, q, spec
+		}
+	}
+	if best == "" || best == ctype {
+		return ""
+	}
+	return strings.TrimPrefix(best, "image/")
+}
+
+// acceptQ returns the q-value the Accept header accept gives ctype, and
+// how specific the range it comes from is: 2 for the type itself, 1 for
+// image/* and 0 for */*. The most specific range that matches counts.
+func acceptQ(accept, ctype string) (q float64, spec int) {
+	spec = -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		var s int
+		switch t := strings.ToLower(strings.TrimSpace(params[0])); {
+		case t == ctype:
+			s = 2
+		case t == "image/*" && strings.HasPrefix(ctype, "image/"):
+			s = 1
+		case t == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s < spec {
+			continue
+		}
+		v := 1.0
+		for _, p := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if f, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					v = f
+				}
+			}
+		}
+		q, spec = v, s
+	}
+	return q, spec
+}
@@ -0,0 +1,262 @@
+package blackbar
+
+// Administrative handlers. They live under /admin/, which app.yaml
+// restricts to administrators of the application. That does not stop
+// another site making an administrator's browser call them, so they
+// take only POSTs carrying a CSRF token (see csrf.go). A script can
+// pick any token, sending it both as the csrf cookie and in the
+// X-CSRF-Token header.
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// rekeyBatch is the number of images rekey looks at per request.
+const rekeyBatch = 50
+
+// rekey is the HTTP handler for migrating images stored under legacy
+// keys; it handles "/admin/rekey". Keys shorter than what keyOf now
+// produces come from an older, truncated hash; rekey moves each such
+// image to the key keyOf gives its data. A key that is already taken is
+// logged as a collision and the image left where it is.
+//
+// Each request handles one batch and reports a cursor for the next; an
+// empty cursor means the scan is complete.
+func rekey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "rekeying requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	c := appengine.NewContext(r)
+	q := datastore.NewQuery("Image").Limit(rekeyBatch)
+	if s := r.FormValue("cursor"); s != "" {
+		cursor, err := datastore.DecodeCursor(s)
+		if err != nil {
+			http.Error(w, "bad cursor", http.StatusBadRequest)
+			return
+		}
+		q = q.Start(cursor)
+	}
+
+	var res struct {
+		Scanned    int    `json:"scanned"`
+		Rekeyed    int    `json:"rekeyed"`
+		Collisions int    `json:"collisions"`
+		Cursor     string `json:"cursor"`
+	}
+	t := q.Run(c)
+	for {
+		im := new(Image)
+		key, err := t.Next(im)
+		if err == datastore.Done {
+			break
+		}
+		check(err)
+		res.Scanned++
+		id, move := rekeyTarget(key.StringID(), im.Data)
+		if !move {
+			continue
+		}
+		newKey := datastore.NewKey(c, "Image", id, 0, nil)
+		err = datastore.RunInTransaction(c, func(c appengine.Context) error {
+			if err := datastore.Get(c, newKey, new(Image)); err != datastore.ErrNoSuchEntity {
+				if err == nil {
+					err = errCollision
+				}
+				return err
+			}
+			if _, err := datastore.Put(c, newKey, im); err != nil {
+				return err
+			}
+			return datastore.Delete(c, key)
+		}, &datastore.TransactionOptions{XG: true})
+		switch err {
+		case nil:
+			forget(c, key.StringID())
+			c.Infof("rekey: moved %s to %s", key.StringID(), newKey.StringID())
+			res.Rekeyed++
+		case errCollision:
+			c.Warningf("rekey: %s collides with existing image %s; left in place", key.StringID(), newKey.StringID())
+			res.Collisions++
+		default:
+			check(err)
+		}
+	}
+	if res.Scanned == rekeyBatch {
+		cursor, err := t.Cursor()
+		check(err)
+		res.Cursor = cursor.String()
+	}
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
+}
+
+// rekeyTarget returns the key of the image with the given data, stored
+// under id, and whether rekey must move it there: it must if id is
+// shorter than what keyOf produces.
+func rekeyTarget(id string, data []byte) (string, bool) {
+	if len(id) >= len(keyOf(nil)) {
+		return id, false
+	}
+	return keyOf(data), true
+}
+
+// applyAllBatch is the number of images applyAll paints per request.
+const applyAllBatch = 20
+
+// applyAll is the HTTP handler for painting the same blackbar onto many
+// stored images; it handles "/admin/applyall". The form values describe
+// the bar as for img, and from and to, if given, restrict it to images
+// uploaded in that interval (RFC 3339 times, from inclusive, to
+// exclusive). Each image is read, painted and stored in a transaction of
+// its own, so a concurrent save is never lost. The bar is saved as an
+// edit, which can be undone like any other.
+//
+// Like rekey, each request handles one batch and reports a cursor for
+// the next; an empty cursor means every matching image is done.
+func applyAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "applying a blackbar requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	win, err := uploadWindowOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c := appengine.NewContext(r)
+	q := datastore.NewQuery("Image").KeysOnly().Order("Uploaded").Limit(applyAllBatch)
+	if !win.from.IsZero() {
+		q = q.Filter("Uploaded >=", win.from)
+	}
+	if !win.to.IsZero() {
+		q = q.Filter("Uploaded <", win.to)
+	}
+	if s := r.FormValue("cursor"); s != "" {
+		cursor, err := datastore.DecodeCursor(s)
+		if err != nil {
+			http.Error(w, "bad cursor", http.StatusBadRequest)
+			return
+		}
+		q = q.Start(cursor)
+	}
+
+	var res applyResult
+	edit := editOf(r)
+	t := q.Run(c)
+	for {
+		key, err := t.Next(nil)
+		if err == datastore.Done {
+			break
+		}
+		check(err)
+		var applied bool
+		err = datastore.RunInTransaction(c, func(c appengine.Context) error {
+			im := new(Image)
+			if err := datastore.Get(c, key, im); err != nil {
+				return err
+			}
+			if applied, err = applyTo(im, win, edit); err != nil || !applied {
+				return err
+			}
+			_, err := datastore.Put(c, key, im)
+			return err
+		}, nil)
+		if err != nil {
+			c.Warningf("applyall: %s: %v", key.StringID(), err)
+		} else if applied {
+			forget(c, key.StringID())
+		}
+		res.add(applied, err)
+	}
+	if res.Processed+res.Skipped+res.Failed == applyAllBatch {
+		cursor, err := t.Cursor()
+		check(err)
+		res.Cursor = cursor.String()
+	}
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
+}
+
+// An uploadWindow is the interval of upload times applyAll works on.
+// A zero from or to leaves that end open.
+type uploadWindow struct {
+	from, to time.Time
+}
+
+// uploadWindowOf returns the window given by the from and to form values
+// of r.
+func uploadWindowOf(r *http.Request) (uploadWindow, error) {
+	var win uploadWindow
+	for _, f := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &win.from}, {"to", &win.to}} {
+		if s := r.FormValue(f.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return win, errors.New("bad time for " + f.name + ": " + s)
+			}
+			*f.t = t
+		}
+	}
+	return win, nil
+}
+
+// contains reports whether t lies within win.
+func (win uploadWindow) contains(t time.Time) bool {
+	return (win.from.IsZero() || !t.Before(win.from)) && (win.to.IsZero() || t.Before(win.to))
+}
+
+// applyTo applies edit to im, as applyEdit does, if im was uploaded
+// within win, and reports whether it did. The query applyAll runs
+// selects by upload time already, but its index may lag behind, so the
+// image as read in the transaction has the last word.
+func applyTo(im *Image, win uploadWindow, edit url.Values) (bool, error) {
+	if !win.contains(im.Uploaded) {
+		return false, nil
+	}
+	if err := applyEdit(im, edit, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// applyResult is what applyAll reports of a batch.
+type applyResult struct {
+	Processed int    `json:"processed"`
+	Skipped   int    `json:"skipped"`
+	Failed    int    `json:"failed"`
+	Cursor    string `json:"cursor"`
+}
+
+// add counts one image, to which applyTo applied the edit or not, or
+// failed with err.
+func (res *applyResult) add(applied bool, err error) {
+	switch {
+	case err != nil:
+		res.Failed++
+	case applied:
+		res.Processed++
+	default:
+		res.Skipped++
+	}
+}
+
+// errCollision reports that an image's new key is already in use.
+var errCollision = errors.New("key already in use")
@@ -0,0 +1,107 @@
+package blackbar
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAdminNeedsPOSTAndToken checks that the administrative handlers,
+// which change stored images, refuse GETs and cross-site POSTs.
+func TestAdminNeedsPOSTAndToken(t *testing.T) {
+	for _, h := range []struct {
+		name string
+		fn   http.HandlerFunc
+	}{{"rekey", rekey}, {"applyAll", applyAll}} {
+		w := httptest.NewRecorder()
+		h.fn(w, httptest.NewRequest("GET", "/admin/x", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: GET got %d, want %d", h.name, w.Code, http.StatusMethodNotAllowed)
+		}
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/admin/x", strings.NewReader("x=10&y=10"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "token"})
+		h.fn(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: POST without token got %d, want %d", h.name, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+// TestRekeyTarget checks, over images stored under legacy and current
+// keys, which rekey moves, and where to.
+func TestRekeyTarget(t *testing.T) {
+	a, b := []byte("image a"), []byte("image b")
+	for _, tt := range []struct {
+		id   string
+		data []byte
+		want string
+		move bool
+	}{
+		{keyOf(a)[:8], a, keyOf(a), true},  // legacy, truncated
+		{keyOf(b)[:16], b, keyOf(b), true}, // legacy, less truncated
+		{keyOf(a), a, keyOf(a), false},     // current
+		{"00000000", a, keyOf(a), true},    // legacy, whatever the hash
+		{keyOf(b) + "x", b, keyOf(b) + "x", false},
+	} {
+		got, move := rekeyTarget(tt.id, tt.data)
+		if got != tt.want || move != tt.move {
+			t.Errorf("rekeyTarget(%q) = %q, %v, want %q, %v", tt.id, got, move, tt.want, tt.move)
+		}
+	}
+}
+
+// TestApplyAllSelection checks, over seeded images uploaded at various
+// times, that applyAll's edit goes onto those uploaded within the window
+// and no others, and that each is counted as it should be.
+func TestApplyAllSelection(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	day := func(d int) time.Time { return time.Date(2013, 3, d, 12, 0, 0, 0, time.UTC) }
+	images := map[string]*Image{
+		"before":    {Uploaded: day(1)},
+		"from":      {Uploaded: time.Date(2013, 3, 2, 0, 0, 0, 0, time.UTC)},
+		"within":    {Uploaded: day(3)},
+		"to":        {Uploaded: time.Date(2013, 3, 5, 0, 0, 0, 0, time.UTC)},
+		"after":     {Uploaded: day(9)},
+		"animation": {Uploaded: day(3), ContentType: "image/gif", Data: encodeGIF(t, testAnimation(2, 20, 20))},
+	}
+	for _, im := range images {
+		if im.Data == nil {
+			im.Data = encodePNG(t, solidImage(100, 50, white))
+			im.ContentType = "image/png"
+		}
+	}
+	r := httptest.NewRequest("POST", "/admin/applyall?x=50&y=25&s=0&from=2013-03-02T00:00:00Z&to=2013-03-05T00:00:00Z", nil)
+	win, err := uploadWindowOf(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res applyResult
+	for _, im := range images {
+		res.add(applyTo(im, win, editOf(r)))
+	}
+	if want := (applyResult{Processed: 2, Skipped: 3, Failed: 1}); res != want {
+		t.Errorf("result %+v, want %+v", res, want)
+	}
+	for name, im := range images {
+		if want := name == "from" || name == "within"; (len(im.Edits) == 1) != want {
+			t.Errorf("%s: edits %q, want applied %v", name, im.Edits, want)
+		}
+	}
+
+	// Without from and to, every image is in the window.
+	win, err = uploadWindowOf(httptest.NewRequest("POST", "/admin/applyall", nil))
+	if err != nil || !win.contains(day(1)) || !win.contains(time.Time{}) {
+		t.Errorf("open window %+v, %v: want every time within it", win, err)
+	}
+	for _, q := range []string{"from=yesterday", "to=2013-03-05"} {
+		if _, err := uploadWindowOf(httptest.NewRequest("POST", "/admin/applyall?"+q, nil)); err == nil {
+			t.Errorf("%s accepted, want an error", q)
+		}
+	}
+}
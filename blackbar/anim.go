@@ -0,0 +1,206 @@
+package blackbar
+
+// Animated GIFs. These are stored as uploaded, frame for frame, and img
+// paints bars onto every frame. Only bars are supported: the other edits
+// img offers work on a single picture.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"net/http"
+	"sync"
+
+	"appengine"
+	"appengine/datastore"
+	"censor"
+)
+
+// errAnimated reports an edit that cannot be saved on an animation.
+var errAnimated = errors.New("edits cannot be saved on animated images")
+
+// decodeAnimation decodes data as an animated GIF. It returns nil if data
+// is not a GIF or has a single frame, which is stored like any other
+// image, and an error if it has more than MaxFrames.
+func decodeAnimation(data []byte) (*gif.GIF, error) {
+	g, err := decodeFrames(data)
+	if _, ok := err.(clientError); ok {
+		return nil, err
+	}
+	if err != nil || len(g.Image) < 2 {
+		return nil, nil
+	}
+	return g, nil
+}
+
+// decodeFrames decodes every frame of the GIF data. One of more than
+// MaxFrames frames is the client's error, found before any is decoded.
+func decodeFrames(data []byte) (*gif.GIF, error) {
+	if n := gifFrames(data); n > MaxFrames {
+		return nil, badRequest(fmt.Errorf("animated GIF has %d frames, more than the limit of %d", n, MaxFrames))
+	}
+	return gif.DecodeAll(bytes.NewReader(data))
+}
+
+// gifFrames returns the number of frames in the GIF data, found by
+// walking its blocks without decompressing any. It counts those found
+// before the data ends or stops making sense; gif.DecodeAll judges the
+// rest.
+func gifFrames(data []byte) int {
+	if len(data) < 13 || string(data[:3]) != "GIF" {
+		return 0
+	}
+	p := 13
+	if data[10]&0x80 != 0 { // global color table
+		p += 3 << (data[10]&7 + 1)
+	}
+	// blocks skips a run of data sub-blocks, which ends with an
+	// empty one.
+	blocks := func() bool {
+		for p < len(data) {
+			n := int(data[p])
+			p += 1 + n
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	n := 0
+	for p < len(data) {
+		switch data[p] {
+		case 0x21: // extension: introducer, label, sub-blocks
+			p += 2
+			if !blocks() {
+				return n
+			}
+		case 0x2c: // image: descriptor, color table, code size, sub-blocks
+			if p+10 > len(data) {
+				return n
+			}
+			if f := data[p+9]; f&0x80 != 0 {
+				p += 3 << (f&7 + 1)
+			}
+			p += 11
+			n++
+			if !blocks() {
+				return n
+			}
+		default: // the trailer, or garbage
+			return n
+		}
+	}
+	return n
+}
+
+// storeAnimation stores the animation g, with DefaultBars painted on,
+// and returns its key. Animations are not shrunk, so those larger than
+// MaxDimension are refused. It panics, through check, on failure.
+func storeAnimation(c appengine.Context, g *gif.GIF) *datastore.Key {
+	canvas := animationBounds(g)
+	if canvas.Dx() > MaxDimension || canvas.Dy() > MaxDimension {
+		check(badRequest(fmt.Errorf("animated GIFs may be at most %dx%d pixels", MaxDimension, MaxDimension)))
+	}
+	paintFrames(g, DefaultBars, image.NewUniform(color.Black), censor.Solid)
+	var buf bytes.Buffer
+	check(gif.EncodeAll(&buf, g))
+	return insert(c, &Image{
+		Data:        buf.Bytes(),
+		Width:       canvas.Dx(),
+		Height:      canvas.Dy(),
+		ContentType: "image/gif",
+	})
+}
+
+// animationBounds returns the bounds of the canvas g is drawn on.
+func animationBounds(g *gif.GIF) image.Rectangle {
+	if g.Config.Width > 0 && g.Config.Height > 0 {
+		return image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	}
+	var r image.Rectangle
+	for _, f := range g.Image {
+		r = r.Union(f.Bounds())
+	}
+	return r
+}
+
+// paintFrames paints bars onto every frame of g, as censor.Paint does.
+// Each frame keeps its palette, so bars come out in the nearest colors
+// it has. Frames are painted concurrently, each in place; fill is only
+// read. The palettes and disposal methods are left as they are, so the
+// result is the same as painting the frames one after another.
+func paintFrames(g *gif.GIF, bars []censor.Bar, fill image.Image, mode censor.Mode) {
+	if len(bars) == 0 {
+		return
+	}
+	eachFrame(len(g.Image), func(i int) {
+		f := g.Image[i]
+		dst := censor.Paint(f, bars, fill, mode)
+		draw.Draw(f, f.Bounds(), dst, f.Bounds().Min, draw.Src)
+		censor.Release(dst)
+	})
+}
+
+// eachFrame calls fn for each frame number from 0 to n-1, from up to
+// FrameWorkers goroutines at once, and returns once all calls have.
+func eachFrame(n int, fn func(i int)) {
+	frames := make(chan int)
+	var wg sync.WaitGroup
+	for k := 0; k == 0 || k < FrameWorkers && k < n; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range frames {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		frames <- i
+	}
+	close(frames)
+	wg.Wait()
+}
+
+// serveAnimation is the part of img that serves the animation im with
+// the bars r asks for painted onto every frame. Bars, their fill and
+// mode are all that apply; saving is refused.
+func serveAnimation(c appengine.Context, w http.ResponseWriter, r *http.Request, im *Image, etag string) {
+	if r.FormValue("n") != "" {
+		check(badRequest(errAnimated))
+	}
+	check(badRequest(checkOperations(r)))
+	g, err := decodeFrames(im.Data)
+	check(err)
+	canvas := animationBounds(g)
+	bars, err := barsOf(r)
+	check(badRequest(err))
+	for i := range bars {
+		b := &bars[i]
+		clampSize(b, canvas)
+		if b.X < 0 || b.Y < 0 {
+			check(badRequest(fmt.Errorf("bar at (%d, %d) has a negative coordinate", b.X, b.Y)))
+		}
+		if b.X > 0 && !b.Rect().Overlaps(canvas) {
+			check(badRequest(fmt.Errorf("bar at (%d, %d) is outside the %dx%d image", b.X, b.Y, canvas.Dx(), canvas.Dy())))
+		}
+	}
+	mode := censor.Mode(r.FormValue("mode"))
+	switch mode {
+	case "", censor.Solid, censor.Blurred, censor.Pixelated, censor.Outlined:
+	default:
+		check(badRequest(fmt.Errorf("unknown mode %q", mode)))
+	}
+	// The first frame stands for the canvas when choosing the fill.
+	fill, err := fillOf(r, g.Image[0])
+	check(badRequest(err))
+	paintFrames(g, bars, fill, mode)
+
+	var buf bytes.Buffer
+	check(gif.EncodeAll(&buf, g))
+	writeRendition(c, w, r, "image/gif", buf.Bytes(), etag)
+}
@@ -0,0 +1,144 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"censor"
+)
+
+// testAnimation returns an animation of n frames, w by h pixels, each
+// a different pattern drawn from the Plan 9 palette.
+func testAnimation(n, w, h int) *gif.GIF {
+	g := &gif.GIF{Config: image.Config{Width: w, Height: h}}
+	for i := 0; i < n; i++ {
+		f := image.NewPaletted(image.Rect(0, 0, w, h), palette.Plan9)
+		for j := range f.Pix {
+			f.Pix[j] = uint8(j/7 + i*13)
+		}
+		g.Image = append(g.Image, f)
+		g.Delay = append(g.Delay, 5)
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	return g
+}
+
+// encodedFrames returns g painted with bars, with the given number of
+// frame workers, encoded.
+func encodedFrames(t testing.TB, workers int, g *gif.GIF, mode censor.Mode) []byte {
+	defer func(n int) { FrameWorkers = n }(FrameWorkers)
+	FrameWorkers = workers
+	bars := []censor.Bar{{X: 60, Y: 40, S: 1}, {X: 120, Y: 90, S: 0, A: 30}}
+	paintFrames(g, bars, image.NewUniform(color.Black), mode)
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestPaintFramesParallel checks that painting frames concurrently gives
+// what painting them one by one does, frames in the same order.
+func TestPaintFramesParallel(t *testing.T) {
+	for _, mode := range []censor.Mode{censor.Solid, censor.Blurred, censor.Pixelated} {
+		serial := encodedFrames(t, 1, testAnimation(12, 160, 120), mode)
+		parallel := encodedFrames(t, 4, testAnimation(12, 160, 120), mode)
+		if !bytes.Equal(serial, parallel) {
+			t.Errorf("mode %s: parallel output differs from serial", mode)
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(parallel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(g.Image) != 12 || g.Disposal[0] != gif.DisposalBackground {
+			t.Errorf("mode %s: %d frames, disposal %d; want 12 frames, disposal %d",
+				mode, len(g.Image), g.Disposal[0], gif.DisposalBackground)
+		}
+	}
+}
+
+func benchmarkPaintFrames(b *testing.B, workers int) {
+	defer func(n int) { FrameWorkers = n }(FrameWorkers)
+	FrameWorkers = workers
+	bars := []censor.Bar{{X: 100, Y: 75, S: 2}}
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		g := testAnimation(16, 200, 150)
+		b.StartTimer()
+		paintFrames(g, bars, nil, censor.Blurred)
+	}
+}
+
+func BenchmarkPaintFramesSerial(b *testing.B)   { benchmarkPaintFrames(b, 1) }
+func BenchmarkPaintFramesParallel(b *testing.B) { benchmarkPaintFrames(b, 4) }
+
+// encodeGIF returns g encoded.
+func encodeGIF(t *testing.T, g *gif.GIF) []byte {
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFFrames(t *testing.T) {
+	for _, n := range []int{1, 2, 12} {
+		g := testAnimation(n, 30, 20)
+		data := encodeGIF(t, g)
+		if got := gifFrames(data); got != n {
+			t.Errorf("%d frames: gifFrames = %d", n, got)
+		}
+		// The encoder writes a local color table when the palette
+		// differs from the global one.
+		g.Config.ColorModel = color.Palette{color.Black, color.White}
+		if got := gifFrames(encodeGIF(t, g)); got != n {
+			t.Errorf("%d frames with a global palette: gifFrames = %d", n, got)
+		}
+		if got := gifFrames(data[:len(data)/2]); got > n {
+			t.Errorf("%d frames, cut short: gifFrames = %d", n, got)
+		}
+	}
+	if got := gifFrames([]byte("not a GIF at all")); got != 0 {
+		t.Errorf("not a GIF: gifFrames = %d", got)
+	}
+}
+
+// TestFrameLimits checks that animations of more than MaxFrames frames
+// are refused at upload and when served, and that serving one honors
+// MaxOperations.
+func TestFrameLimits(t *testing.T) {
+	defer func(n int) { MaxFrames = n }(MaxFrames)
+	MaxFrames = 5
+	if g, err := decodeAnimation(encodeGIF(t, testAnimation(5, 30, 20))); err != nil || g == nil {
+		t.Errorf("5 frames: %v, %v; want an animation", g, err)
+	}
+	if g, err := decodeAnimation(encodeGIF(t, testAnimation(1, 30, 20))); err != nil || g != nil {
+		t.Errorf("1 frame: %v, %v; want neither animation nor error", g, err)
+	}
+	if _, err := decodeAnimation(encodeGIF(t, testAnimation(6, 30, 20))); err == nil {
+		t.Error("6 frames accepted")
+	}
+
+	im := &Image{Data: encodeGIF(t, testAnimation(6, 30, 20)), ContentType: "image/gif"}
+	serve := func(query string) int {
+		w := httptest.NewRecorder()
+		errorHandler(func(w http.ResponseWriter, r *http.Request) {
+			serveAnimation(nil, w, r, im, "")
+		})(w, httptest.NewRequest("GET", "/img?"+query, nil))
+		return w.Code
+	}
+	if got := serve("x=10&y=10"); got != http.StatusBadRequest {
+		t.Errorf("serving 6 frames: got %d, want %d", got, http.StatusBadRequest)
+	}
+	MaxFrames = 10
+	if got := serve(strings.Repeat("x=10&y=10&", MaxOperations)); got != http.StatusBadRequest {
+		t.Errorf("serving with too many operations: got %d, want %d", got, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,159 @@
+package blackbar
+
+// The JSON API, for scripts. It shares the work with the pages but
+// answers everything, failures included, in JSON.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"appengine"
+	"censor"
+)
+
+// apiHandler wraps the argument API handler with an error-catcher that
+// replies with a JSON error: 400 for errors marked by badRequest and 500
+// for the rest.
+func apiHandler(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			err, ok := v.(error)
+			if !ok {
+				err = errors.New(fmt.Sprint(v))
+				log.Print("Panic: ", err)
+			}
+			status := http.StatusInternalServerError
+			if _, ok := err.(clientError); ok {
+				status = http.StatusBadRequest
+			}
+			noteError(w, err)
+			countFailure(status)
+			apiError(w, status, err.Error())
+		}()
+		fn(w, r)
+	}
+}
+
+// apiError replies to the request with a JSON error object holding msg,
+// and the given status.
+func apiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+// apiUpload is the API handler for uploading images; it handles
+// "/api/upload". The image is the "image" file of a multipart form, or
+// else the whole request body; a scaling query value works as for
+// upload. The reply is {"id": ...}; if URLs must be signed, it also
+// has "query", the signed query string naming the image (see sign.go).
+func apiUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		apiError(w, http.StatusMethodNotAllowed, "uploading requires a POST")
+		return
+	}
+	if r.ContentLength > MaxUploadSize {
+		apiError(w, http.StatusRequestEntityTooLarge, errTooLarge.Error())
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		f, _, err := r.FormFile("image")
+		if bodyTooLarge(err) {
+			apiError(w, http.StatusRequestEntityTooLarge, errTooLarge.Error())
+			return
+		}
+		check(badRequest(err))
+		defer f.Close()
+		src = f
+	}
+	data, err := ioutil.ReadAll(src)
+	if bodyTooLarge(err) {
+		apiError(w, http.StatusRequestEntityTooLarge, errTooLarge.Error())
+		return
+	}
+	check(badRequest(err))
+	how, err := scalingOf(r)
+	check(err)
+	key := store(appengine.NewContext(r), data, 0, how)
+	w.Header().Set("Content-type", "application/json")
+	res := struct {
+		ID    string `json:"id"`
+		Query string `json:"query,omitempty"`
+	}{ID: key.StringID()}
+	if SigningKey != "" {
+		res.Query = signedID(res.ID)
+	}
+	check(json.NewEncoder(w).Encode(res))
+}
+
+// apiBar is the API handler for painting bars; it handles "/api/bar".
+// The request is a JSON object naming the image and the bars to paint,
+// as in {"id": ..., "bars": [{"x": 10, "y": 20, "s": 3}]}, and optionally
+// the format, as for img's fmt, and "datauri": true to have the image
+// as a data URI rather than as is. Nothing is stored. If URLs must be
+// signed, the query string must be signed for the image named.
+func apiBar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		apiError(w, http.StatusMethodNotAllowed, "painting bars requires a POST")
+		return
+	}
+	var req struct {
+		ID      string       `json:"id"`
+		Bars    []censor.Bar `json:"bars"`
+		Format  string       `json:"format"`
+		DataURI bool         `json:"datauri"`
+	}
+	check(badRequest(json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req)))
+	if len(req.Bars) > MaxOperations {
+		check(badRequest(fmt.Errorf("%d bars, more than the limit of %d", len(req.Bars), MaxOperations)))
+	}
+	if SigningKey != "" && req.ID != r.FormValue("id") {
+		apiError(w, http.StatusForbidden, "the signature is for another image")
+		return
+	}
+	c := appengine.NewContext(r)
+	im, _, err := fetch(c, req.ID)
+	check(err)
+	m, _, err := image.Decode(bytes.NewReader(im.Data))
+	check(err)
+	for i := range req.Bars {
+		b := &req.Bars[i]
+		if b.Feather < 0 {
+			b.Feather = 0
+		} else if b.Feather > maxFeather {
+			b.Feather = maxFeather
+		}
+		clampSize(b, m.Bounds())
+	}
+	dst := censor.Paint(m, req.Bars, image.NewUniform(color.Black), censor.Solid)
+	var buf bytes.Buffer
+	ctype, err := output{Format: req.Format}.encode(&buf, dst)
+	check(err)
+	if req.DataURI {
+		w.Header().Set("Content-type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "data:%s;base64,%s", ctype, base64.StdEncoding.EncodeToString(buf.Bytes()))
+		return
+	}
+	w.Header().Set("Content-type", ctype)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,29 @@
+//go:build avif
+// +build avif
+
+package blackbar
+
+// AVIF encoding pulls in a large dependency (libavif, run through a
+// WebAssembly runtime), so it is only compiled in when building with
+// -tags avif.
+
+import (
+	"image"
+	"io"
+
+	"github.com/gen2brain/avif"
+)
+
+func init() {
+	encodeAVIF = func(w io.Writer, m image.Image, quality int) error {
+		if quality == 0 {
+			quality = avif.DefaultQuality
+		}
+		return avif.Encode(w, m, avif.Options{
+			Quality:           quality,
+			QualityAlpha:      quality,
+			Speed:             avif.DefaultSpeed,
+			ChromaSubsampling: image.YCbCrSubsampleRatio420,
+		})
+	}
+}
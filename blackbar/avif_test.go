@@ -0,0 +1,40 @@
+//go:build avif
+// +build avif
+
+package blackbar
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+
+	"github.com/gen2brain/avif"
+)
+
+// TestEncodeAVIF checks that, with the encoder compiled in, fmt=avif
+// output decodes, at the size it was rendered, and that q is honored.
+func TestEncodeAVIF(t *testing.T) {
+	m := solidImage(64, 48, color.RGBA{0x20, 0x80, 0xc0, 0xff})
+	sizes := make(map[int]int)
+	for _, q := range []int{10, 90} {
+		var b bytes.Buffer
+		ctype, err := output{Format: "avif", Quality: q}.encode(&b, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctype != "image/avif" {
+			t.Errorf("q=%d: content type %q, want image/avif", q, ctype)
+		}
+		got, err := avif.Decode(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatalf("q=%d: %v", q, err)
+		}
+		if got.Bounds() != m.Bounds() {
+			t.Errorf("q=%d: decoded %v, want %v", q, got.Bounds(), m.Bounds())
+		}
+		sizes[q] = b.Len()
+	}
+	if sizes[10] > sizes[90] {
+		t.Errorf("q=10 gave %d bytes, more than the %d of q=90", sizes[10], sizes[90])
+	}
+}
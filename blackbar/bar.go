@@ -0,0 +1,236 @@
+package blackbar
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"censor"
+)
+
+// Bounds on the widths of a bar's feathered edge and outline, in pixels.
+const (
+	maxFeather   = 100
+	maxThickness = 100
+)
+
+// barOf returns the bar described by the x, y, s, feather and t (the
+// thickness of an outline) form values of r. Missing values read as
+// zero; malformed ones are an error naming the value.
+func barOf(r *http.Request) (censor.Bar, error) {
+	var b censor.Bar
+	for _, f := range []struct {
+		name string
+		p    *int
+	}{{"x", &b.X}, {"y", &b.Y}, {"s", &b.S}, {"feather", &b.Feather}, {"t", &b.Thickness}} {
+		var err error
+		if *f.p, err = formInt(f.name, r.FormValue(f.name)); err != nil {
+			return censor.Bar{}, err
+		}
+	}
+	if b.Feather < 0 {
+		b.Feather = 0
+	} else if b.Feather > maxFeather {
+		b.Feather = maxFeather
+	}
+	if b.Thickness > maxThickness {
+		b.Thickness = maxThickness
+	}
+	return b, nil
+}
+
+// barsOf returns the bars described by r: the x, y and s form values may
+// each be repeated, the nth of each describing the nth bar, and feather,
+// t and the angle a apply to all. Bars missing one of the three are left
+// out, except that s may be omitted altogether, as in a request for a
+// single bar made before sizes were introduced. A malformed value is an
+// error naming it.
+func barsOf(r *http.Request) ([]censor.Bar, error) {
+	r.ParseForm()
+	xs, ys, ss := r.Form["x"], r.Form["y"], r.Form["s"]
+	n := len(xs)
+	if len(ys) < n {
+		n = len(ys)
+	}
+	if len(ss) < n && len(ss) > 0 {
+		n = len(ss)
+	}
+	first, err := barOf(r)
+	if err != nil {
+		return nil, err
+	}
+	var a float64
+	if s := r.FormValue("a"); s != "" {
+		if a, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("bad a %q: not a number", s)
+		}
+	}
+	var bars []censor.Bar
+	for i := 0; i < n; i++ {
+		b := censor.Bar{Feather: first.Feather, Thickness: first.Thickness, A: a}
+		if b.X, err = formInt("x", xs[i]); err != nil {
+			return nil, err
+		}
+		if b.Y, err = formInt("y", ys[i]); err != nil {
+			return nil, err
+		}
+		if len(ss) > 0 {
+			if b.S, err = formInt("s", ss[i]); err != nil {
+				return nil, err
+			}
+		}
+		bars = append(bars, b)
+	}
+	return bars, nil
+}
+
+// clampSize keeps the size step of b from 0 up to that of the smallest
+// bar covering an image with the given bounds from anywhere on it.
+// Larger bars would paint nothing more, only take longer.
+func clampSize(b *censor.Bar, bounds image.Rectangle) {
+	max := (2*bounds.Dx() + 49) / 50
+	if h := (2*bounds.Dy() + 9) / 10; h > max {
+		max = h
+	}
+	if b.S > max {
+		b.S = max
+	}
+	if b.S < 0 {
+		b.S = 0
+	}
+}
+
+// anchorOf returns the point given by the anchor form value of r, or
+// the origin if there is none. An anchor is the origin the client's
+// coordinates are relative to, such as a landmark a detector found in a
+// scanned form, so one set of bars fits scans that are shifted slightly.
+func anchorOf(r *http.Request) (image.Point, error) {
+	a := r.FormValue("anchor")
+	if a == "" {
+		return image.ZP, nil
+	}
+	p, err := parsePoint(a)
+	if err != nil {
+		return image.ZP, fmt.Errorf("bad anchor: %v", err)
+	}
+	return p, nil
+}
+
+// placeBars moves bars, as r asks for them, to where render paints them
+// on im, whose pixels have the given bounds: by anchor, and into the
+// upright frame if the orient form value is original, which may turn
+// them too. Their sizes are clamped to fit. Bars that would silently
+// paint nothing are an error.
+func placeBars(r *http.Request, im *Image, bars []censor.Bar, anchor image.Point, bounds image.Rectangle) error {
+	for i := range bars {
+		b := &bars[i]
+		b.X, b.Y = b.X+anchor.X, b.Y+anchor.Y
+		clampSize(b, bounds)
+		if r.FormValue("orient") == "original" {
+			p := orientPoint(image.Pt(b.X, b.Y), im.Orientation, shotSize(bounds.Size(), im.Orientation))
+			b.X, b.Y = p.X, p.Y
+			b.A = orientAngle(b.A, im.Orientation)
+		}
+		if b.X < 0 || b.Y < 0 {
+			return fmt.Errorf("bar at (%d, %d) has a negative coordinate", b.X, b.Y)
+		}
+		if b.X > 0 && !b.Rect().Overlaps(bounds) {
+			size := bounds.Size()
+			return fmt.Errorf("bar at (%d, %d) is outside the %dx%d image", b.X, b.Y, size.X, size.Y)
+		}
+	}
+	return nil
+}
+
+// formInt parses v, the form value called name, as an integer. An empty
+// value is zero.
+func formInt(name, v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q: not an integer", name, v)
+	}
+	return i, nil
+}
+
+// maxBoxes bounds the number of boxes a request may carry.
+const maxBoxes = 64
+
+// boxesOf returns the rectangles described by the boxes form value of r,
+// scaled to an image of the given size. The value lists boxes separated
+// by semicolons, each as cx,cy,w,h: the center, width and height as
+// fractions of the image's, the way object detectors such as YOLO
+// report them.
+func boxesOf(r *http.Request, size image.Point) ([]image.Rectangle, error) {
+	s := r.FormValue("boxes")
+	if s == "" {
+		return nil, nil
+	}
+	list := strings.Split(s, ";")
+	if len(list) > maxBoxes {
+		return nil, fmt.Errorf("%d boxes, more than the limit of %d", len(list), maxBoxes)
+	}
+	var rs []image.Rectangle
+	for _, box := range list {
+		f := strings.Split(box, ",")
+		if len(f) != 4 {
+			return nil, fmt.Errorf("bad box %q: want cx,cy,w,h", box)
+		}
+		var v [4]float64
+		for i := range f {
+			x, err := strconv.ParseFloat(strings.TrimSpace(f[i]), 64)
+			if err != nil || !(0 <= x && x <= 1) {
+				return nil, fmt.Errorf("bad box %q: values must be between 0 and 1", box)
+			}
+			v[i] = x
+		}
+		cx, cy, w, h := v[0], v[1], v[2]/2, v[3]/2
+		rs = append(rs, image.Rect(
+			int((cx-w)*float64(size.X)+0.5), int((cy-h)*float64(size.Y)+0.5),
+			int((cx+w)*float64(size.X)+0.5), int((cy+h)*float64(size.Y)+0.5)))
+	}
+	return rs, nil
+}
+
+// uncovered returns the parts of t not covered by any of the rectangles
+// in rs, as a list of disjoint rectangles. An empty result means t is
+// fully covered.
+func uncovered(t image.Rectangle, rs []image.Rectangle) []image.Rectangle {
+	rest := []image.Rectangle{t}
+	for _, r := range rs {
+		var next []image.Rectangle
+		for _, u := range rest {
+			next = append(next, subtract(u, r)...)
+		}
+		rest = next
+	}
+	return rest
+}
+
+// subtract returns the parts of r outside s as up to four disjoint
+// rectangles: full-width bands above and below s, and the pieces to its
+// left and right.
+func subtract(r, s image.Rectangle) []image.Rectangle {
+	in := r.Intersect(s)
+	if in.Empty() {
+		return []image.Rectangle{r}
+	}
+	var out []image.Rectangle
+	if r.Min.Y < in.Min.Y {
+		out = append(out, image.Rect(r.Min.X, r.Min.Y, r.Max.X, in.Min.Y))
+	}
+	if in.Max.Y < r.Max.Y {
+		out = append(out, image.Rect(r.Min.X, in.Max.Y, r.Max.X, r.Max.Y))
+	}
+	if r.Min.X < in.Min.X {
+		out = append(out, image.Rect(r.Min.X, in.Min.Y, in.Min.X, in.Max.Y))
+	}
+	if in.Max.X < r.Max.X {
+		out = append(out, image.Rect(in.Max.X, in.Min.Y, r.Max.X, in.Max.Y))
+	}
+	return out
+}
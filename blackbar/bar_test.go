@@ -0,0 +1,295 @@
+package blackbar
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"censor"
+)
+
+// formRequest returns a request carrying the given form values.
+func formRequest(v url.Values) *http.Request {
+	return &http.Request{Form: v}
+}
+
+// solidImage returns a w by h image filled with c.
+func solidImage(w, h int, c color.Color) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(m, m.Bounds(), image.NewUniform(c), image.ZP, draw.Src)
+	return m
+}
+
+func TestBoxesOf(t *testing.T) {
+	for _, tt := range []struct {
+		boxes string
+		size  image.Point
+		want  []image.Rectangle
+	}{
+		{"0.5,0.5,0.5,0.5", image.Pt(200, 100), []image.Rectangle{image.Rect(50, 25, 150, 75)}},
+		{"0.5,0.5,0.5,0.5", image.Pt(640, 480), []image.Rectangle{image.Rect(160, 120, 480, 360)}},
+		{"0.1,0.2,0.2,0.2;1,1,0.5,0.5", image.Pt(100, 50), []image.Rectangle{
+			image.Rect(0, 5, 20, 15),
+			image.Rect(75, 38, 125, 63),
+		}},
+	} {
+		got, err := boxesOf(formRequest(url.Values{"boxes": {tt.boxes}}), tt.size)
+		if err != nil {
+			t.Errorf("boxesOf(%q, %v): %v", tt.boxes, tt.size, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("boxesOf(%q, %v) = %v, want %v", tt.boxes, tt.size, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("boxesOf(%q, %v)[%d] = %v, want %v", tt.boxes, tt.size, i, got[i], tt.want[i])
+			}
+		}
+	}
+	for _, bad := range []string{"0.5,0.5,0.5", "0.5,0.5,1.5,0.5", "-0.1,0.5,0.5,0.5", "a,b,c,d"} {
+		if _, err := boxesOf(formRequest(url.Values{"boxes": {bad}}), image.Pt(100, 100)); err == nil {
+			t.Errorf("boxesOf(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+// TestBoxesPainted checks that every pixel of a box is censored, in each
+// mode that paints it over, even where the box lies away from the bars.
+func TestBoxesPainted(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	box := image.Rect(130, 130, 170, 170)
+	for _, v := range []url.Values{
+		{},
+		{"fill": {"text"}},
+		{"fill": {"noise"}},
+		{"mode": {"outline"}},
+	} {
+		v.Set("x", "40")
+		v.Set("y", "20")
+		v.Set("boxes", "0.75,0.75,0.2,0.2")
+		dst, err := render(formRequest(v), &Image{}, solidImage(200, 200, red))
+		if err != nil {
+			t.Errorf("render(%v): %v", v, err)
+			continue
+		}
+		// Outlines leave the inside of the box alone.
+		if v.Get("mode") == "outline" {
+			if dst.At(box.Min.X, box.Min.Y) == color.Color(red) {
+				t.Errorf("render(%v): corner of box not outlined", v)
+			}
+			if dst.At(150, 150) != color.Color(red) {
+				t.Errorf("render(%v): inside of outlined box painted", v)
+			}
+			continue
+		}
+		for y := box.Min.Y; y < box.Max.Y; y++ {
+			for x := box.Min.X; x < box.Max.X; x++ {
+				if dst.RGBAAt(x, y) == red {
+					t.Fatalf("render(%v): pixel (%d, %d) of box left unpainted", v, x, y)
+				}
+			}
+		}
+		if dst.RGBAAt(100, 100) != red {
+			t.Errorf("render(%v): pixel (100, 100) outside box painted", v)
+		}
+	}
+}
+
+// TestBoxesModes checks that boxes respect mode=pixelate and blur, changing
+// what is under them but nothing else.
+func TestBoxesModes(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			m.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 0xff})
+		}
+	}
+	orig := image.NewRGBA(m.Bounds())
+	copy(orig.Pix, m.Pix)
+	for _, mode := range []string{"pixelate", "blur"} {
+		copy(m.Pix, orig.Pix)
+		v := url.Values{"mode": {mode}, "boxes": {"0.75,0.75,0.2,0.2"}}
+		dst, err := render(formRequest(v), &Image{}, m)
+		if err != nil {
+			t.Fatalf("render(%v): %v", v, err)
+		}
+		if dst.RGBAAt(151, 151) == orig.RGBAAt(151, 151) {
+			t.Errorf("mode %s: box not censored", mode)
+		}
+		if dst.RGBAAt(100, 100) != orig.RGBAAt(100, 100) {
+			t.Errorf("mode %s: pixel outside box changed", mode)
+		}
+		if dst.RGBAAt(151, 151) == (color.RGBA{0, 0, 0, 0xff}) && mode == "blur" {
+			t.Errorf("mode %s: box painted black rather than blurred", mode)
+		}
+	}
+}
+
+// area returns the total area of rs.
+func area(rs []image.Rectangle) int {
+	n := 0
+	for _, r := range rs {
+		n += r.Dx() * r.Dy()
+	}
+	return n
+}
+
+func TestUncovered(t *testing.T) {
+	target := image.Rect(10, 10, 30, 20)
+	for _, tt := range []struct {
+		name string
+		bars []image.Rectangle
+		want int // uncovered area
+	}{
+		{"covered", []image.Rectangle{image.Rect(0, 0, 40, 40)}, 0},
+		{"covered by two", []image.Rectangle{image.Rect(0, 0, 20, 40), image.Rect(20, 0, 40, 40)}, 0},
+		{"left half", []image.Rectangle{image.Rect(0, 0, 20, 40)}, 100},
+		{"middle", []image.Rectangle{image.Rect(15, 12, 25, 18)}, 140},
+		{"overlapping", []image.Rectangle{image.Rect(0, 0, 20, 15), image.Rect(15, 0, 25, 15)}, 125},
+		{"apart", []image.Rectangle{image.Rect(50, 50, 60, 60)}, 200},
+		{"none", nil, 200},
+	} {
+		rest := uncovered(target, tt.bars)
+		if got := area(rest); got != tt.want {
+			t.Errorf("%s: uncovered area %d, want %d (%v)", tt.name, got, tt.want, rest)
+		}
+		for i, r := range rest {
+			if !r.In(target) {
+				t.Errorf("%s: %v lies outside the target", tt.name, r)
+			}
+			for _, b := range tt.bars {
+				if r.Overlaps(b) {
+					t.Errorf("%s: %v overlaps bar %v", tt.name, r, b)
+				}
+			}
+			for _, s := range rest[i+1:] {
+				if r.Overlaps(s) {
+					t.Errorf("%s: %v overlaps %v", tt.name, r, s)
+				}
+			}
+		}
+	}
+}
+
+// TestValidate checks the report validate gives on bars that cover the
+// target wholly, partly, and not at all.
+func TestValidate(t *testing.T) {
+	// The bar covers (75, 45) to (125, 55).
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"tx=80&ty=46&tw=40&th=8", `{"covered":true,"uncovered":[]}`},
+		{"tx=100&ty=46&tw=40&th=8", `{"covered":false,"uncovered":[{"x":125,"y":46,"w":15,"h":8}]}`},
+		{"tx=0&ty=0&tw=10&th=10", `{"covered":false,"uncovered":[{"x":0,"y":0,"w":10,"h":10}]}`},
+	} {
+		w := httptest.NewRecorder()
+		validate(w, httptest.NewRequest("GET", "/validate?x=100&y=50&s=0&"+tt.query, nil))
+		if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+			t.Errorf("validate(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+	w := httptest.NewRecorder()
+	validate(w, httptest.NewRequest("GET", "/validate?x=100&y=50&tw=0&th=10", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("empty target: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestAnchor checks that bars are placed relative to the anchor, so the
+// same bars land on the same content of a shifted scan.
+func TestAnchor(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	for _, anchor := range []image.Point{{0, 0}, {30, 20}, {-10, 5}} {
+		// Content at (100, 50) in the scan; its landmark at anchor.
+		v := url.Values{
+			"x":      {strconv.Itoa(100 - anchor.X)},
+			"y":      {strconv.Itoa(50 - anchor.Y)},
+			"s":      {"0"},
+			"anchor": {fmt.Sprintf("%d,%d", anchor.X, anchor.Y)},
+		}
+		dst, err := render(formRequest(v), &Image{}, solidImage(200, 100, white))
+		if err != nil {
+			t.Errorf("anchor %v: %v", anchor, err)
+			continue
+		}
+		var got image.Rectangle
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 200; x++ {
+				if dst.RGBAAt(x, y) != white {
+					got = got.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		if want := image.Rect(75, 45, 125, 55); got != want {
+			t.Errorf("anchor %v: painted %v, want %v", anchor, got, want)
+		}
+	}
+	for _, v := range []url.Values{
+		{"x": {"10"}, "y": {"10"}, "anchor": {"-20,0"}},
+		{"x": {"10"}, "y": {"10"}, "anchor": {"a,b"}},
+	} {
+		if _, err := render(formRequest(v), &Image{}, solidImage(200, 100, white)); err == nil {
+			t.Errorf("render(%v) succeeded, want an error", v)
+		}
+	}
+}
+
+func TestClampSize(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	for _, tt := range []struct{ s, want int }{
+		{-5, 0},
+		{0, 0},
+		{3, 3},
+		{20, 20},
+		{1000, 20}, // 1050 by 210 covers 200 by 100 from a corner
+	} {
+		b := censor.Bar{X: 100, Y: 50, S: tt.s}
+		clampSize(&b, bounds)
+		if b.S != tt.want {
+			t.Errorf("clampSize(s=%d) = %d, want %d", tt.s, b.S, tt.want)
+		}
+	}
+}
+
+// TestBarSizes checks the bars render paints for sizes below zero, at
+// zero, and beyond the image.
+func TestBarSizes(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	for _, tt := range []struct {
+		s, x, y string
+		want    image.Rectangle
+	}{
+		{"-5", "100", "50", image.Rect(75, 45, 125, 55)},
+		{"0", "100", "50", image.Rect(75, 45, 125, 55)},
+		{"1000", "100", "50", image.Rect(0, 0, 200, 100)},
+		{"1000", "1", "1", image.Rect(0, 0, 200, 100)},
+	} {
+		v := url.Values{"x": {tt.x}, "y": {tt.y}, "s": {tt.s}}
+		dst, err := render(formRequest(v), &Image{}, solidImage(200, 100, white))
+		if err != nil {
+			t.Errorf("s=%s: %v", tt.s, err)
+			continue
+		}
+		var got image.Rectangle
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 200; x++ {
+				if dst.RGBAAt(x, y) != white {
+					got = got.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		if got != tt.want {
+			t.Errorf("s=%s at (%s, %s): painted %v, want %v", tt.s, tt.x, tt.y, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package blackbar
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+	"censor"
+)
+
+// maxBatchFiles bounds the number of files in a batch.
+const maxBatchFiles = 100
+
+// batch is the HTTP handler for uploading many images at once; it
+// handles "/batch". The "zip" file of the form is a ZIP archive of
+// images, each of which is stored as though uploaded on its own. The
+// reply is a JSON list giving the id of each, or a warning for a file
+// that could not be stored, which does not stop the rest.
+//
+// The archive is bounded by MaxUploadSize, each file in it by the same,
+// and the files together by MaxBatchSize once uncompressed, however
+// small the archive claims they are.
+func batch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "uploading a batch requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !parseUpload(w, r, "Archives") {
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	how, err := scalingOf(r)
+	check(err)
+	f, _, err := r.FormFile("zip")
+	check(badRequest(err))
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	check(badRequest(err))
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	check(badRequest(err))
+	if len(zr.File) > maxBatchFiles {
+		check(badRequest(fmt.Errorf("archive has %d files, more than the limit of %d", len(zr.File), maxBatchFiles)))
+	}
+
+	type result struct {
+		Filename string `json:"filename"`
+		ID       string `json:"id,omitempty"`
+		Query    string `json:"query,omitempty"` // if URLs must be signed
+		Warning  string `json:"warning,omitempty"`
+	}
+	res := []result{}
+	c := appengine.NewContext(r)
+	var total int64
+	for _, zf := range zr.File {
+		if strings.HasSuffix(zf.Name, "/") {
+			continue // a directory
+		}
+		rs := result{Filename: zf.Name}
+		data, err := readZipped(zf, MaxBatchSize-total)
+		if err == errBatchTooLarge {
+			check(badRequest(fmt.Errorf("archive holds more than %d MB of files", MaxBatchSize>>20)))
+		}
+		total += int64(len(data))
+		if err == nil {
+			var key *datastore.Key
+			if key, err = tryStore(c, data, how); err == nil {
+				rs.ID = key.StringID()
+				if SigningKey != "" {
+					rs.Query = signedID(rs.ID)
+				}
+			}
+		}
+		if err != nil {
+			c.Warningf("batch: %s: %v", zf.Name, err)
+			rs.Warning = err.Error()
+		}
+		res = append(res, rs)
+	}
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
+}
+
+// errBatchTooLarge reports that the files of a batch are too large
+// together.
+var errBatchTooLarge = errors.New("batch too large")
+
+// readZipped returns the uncompressed contents of zf, reading no more
+// than room bytes, nor more than MaxUploadSize. It returns
+// errBatchTooLarge if there is more than room.
+func readZipped(zf *zip.File, room int64) ([]byte, error) {
+	limit := MaxUploadSize
+	if room < limit {
+		limit = room
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if n := int64(len(data)); n > room {
+		return nil, errBatchTooLarge
+	} else if n > MaxUploadSize {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
+
+// tryStore is store, returning its failure rather than panicking.
+func tryStore(c appengine.Context, data []byte, how censor.Scaling) (key *datastore.Key, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			if e, ok := v.(error); ok {
+				err = e
+				return
+			}
+			panic(v)
+		}
+	}()
+	return store(c, data, 0, how), nil
+}
@@ -0,0 +1,133 @@
+package blackbar
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+
+	"appengine"
+	"censor"
+)
+
+// bundleThumbWidths are the widths of the thumbnails included in a
+// package, largest first. Thumbnails no smaller than the image are
+// left out.
+var bundleThumbWidths = []int{400, 100}
+
+// maxBundleSize bounds the size of the ZIP served by bundle.
+const maxBundleSize = 8 << 20
+
+// bundle is the HTTP handler for downloading an edit session as a single
+// ZIP archive; it handles "/package". The archive holds the barred image
+// at full size, a few thumbnails of it, the bar spec as JSON and a
+// manifest describing the other files.
+func bundle(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	id := r.FormValue("id")
+	im, _, err := fetch(c, id)
+	check(err)
+	m, _, err := image.Decode(bytes.NewBuffer(im.Data))
+	check(err)
+	dst, err := render(r, im, m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bars, err := bundleBars(r, im, m.Bounds())
+	check(err) // checked by render
+	data := packBundle(id, dst, bars)
+	if len(data) > maxBundleSize {
+		check(fmt.Errorf("package is %d bytes, over the limit of %d", len(data), maxBundleSize))
+	}
+	w.Header().Set("Content-type", "application/zip")
+	w.Header().Set("Content-disposition", fmt.Sprintf("attachment; filename=%q", id+".zip"))
+	w.Write(data)
+}
+
+// bundleBars returns the bars r asks for, as render paints them on im,
+// whose pixels have the given bounds, and in the coordinates of the
+// image it renders: placed by anchor and orientation, then moved with
+// the crop, if any. Bars that paint nothing are left out.
+func bundleBars(r *http.Request, im *Image, bounds image.Rectangle) ([]censor.Bar, error) {
+	anchor, err := anchorOf(r)
+	if err != nil {
+		return nil, err
+	}
+	bars, err := barsOf(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := placeBars(r, im, bars, anchor, bounds); err != nil {
+		return nil, err
+	}
+	origin := bounds.Min
+	crop, ok, err := cropOf(r, bounds)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		origin = crop.Min
+	}
+	placed := []censor.Bar{}
+	for _, b := range bars {
+		if b.X > 0 {
+			b.X, b.Y = b.X-origin.X, b.Y-origin.Y
+			placed = append(placed, b)
+		}
+	}
+	return placed, nil
+}
+
+// packBundle returns the ZIP archive bundle serves for image id, barred
+// with bars, in its coordinates, to give dst. It panics, through check,
+// on failure.
+func packBundle(id string, dst image.Image, bars []censor.Bar) []byte {
+	type entry struct {
+		Name   string `json:"name"`
+		Width  int    `json:"width,omitempty"`
+		Height int    `json:"height,omitempty"`
+		Bytes  int    `json:"bytes"`
+	}
+	var (
+		buf      bytes.Buffer
+		zw       = zip.NewWriter(&buf)
+		manifest = struct {
+			ID    string  `json:"id"`
+			Files []entry `json:"files"`
+		}{ID: id}
+	)
+	add := func(name string, data []byte, size image.Point) {
+		f, err := zw.Create(name)
+		check(err)
+		_, err = f.Write(data)
+		check(err)
+		manifest.Files = append(manifest.Files, entry{name, size.X, size.Y, len(data)})
+	}
+	addJPEG := func(name string, m image.Image) {
+		var b bytes.Buffer
+		check(jpeg.Encode(&b, m, nil))
+		add(name, b.Bytes(), m.Bounds().Size())
+	}
+
+	addJPEG("image.jpg", dst)
+	for _, tw := range bundleThumbWidths {
+		if tw < dst.Bounds().Dx() {
+			addJPEG(fmt.Sprintf("thumb-%d.jpg", tw), thumbnail(dst, tw))
+		}
+	}
+	spec := struct {
+		Bars []censor.Bar `json:"bars"`
+	}{append([]censor.Bar{}, bars...)}
+	data, err := json.MarshalIndent(spec, "", "\t")
+	check(err)
+	add("bars.json", data, image.ZP)
+	data, err = json.MarshalIndent(manifest, "", "\t")
+	check(err)
+	add("manifest.json", data, image.ZP)
+	check(zw.Close())
+	return buf.Bytes()
+}
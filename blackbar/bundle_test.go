@@ -0,0 +1,137 @@
+package blackbar
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"censor"
+)
+
+// TestPackBundle checks the files in a package, and that the manifest
+// describes them.
+func TestPackBundle(t *testing.T) {
+	dst := solidImage(600, 300, color.White)
+	bars := []censor.Bar{{X: 300, Y: 150, S: 2}}
+	data := packBundle("abc", dst, bars)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = b
+		names = append(names, f.Name)
+	}
+	want := []string{"image.jpg", "thumb-400.jpg", "thumb-100.jpg", "bars.json", "manifest.json"}
+	if len(names) != len(want) {
+		t.Fatalf("files %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("files %v, want %v", names, want)
+		}
+	}
+
+	var manifest struct {
+		ID    string
+		Files []struct {
+			Name          string
+			Width, Height int
+			Bytes         int
+		}
+	}
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ID != "abc" {
+		t.Errorf("manifest id %q, want abc", manifest.ID)
+	}
+	sizes := map[string]image.Point{"image.jpg": {600, 300}, "thumb-400.jpg": {400, 200}, "thumb-100.jpg": {100, 50}}
+	for _, e := range manifest.Files {
+		if e.Bytes != len(files[e.Name]) {
+			t.Errorf("manifest gives %s %d bytes, want %d", e.Name, e.Bytes, len(files[e.Name]))
+		}
+		size, ok := sizes[e.Name]
+		if !ok {
+			continue
+		}
+		c, _, err := image.DecodeConfig(bytes.NewReader(files[e.Name]))
+		if err != nil {
+			t.Errorf("%s: %v", e.Name, err)
+			continue
+		}
+		if got := image.Pt(c.Width, c.Height); got != size || image.Pt(e.Width, e.Height) != size {
+			t.Errorf("%s is %v, listed as %dx%d, want %v", e.Name, got, e.Width, e.Height, size)
+		}
+	}
+
+	var spec struct{ Bars []censor.Bar }
+	if err := json.Unmarshal(files["bars.json"], &spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Bars) != 1 || spec.Bars[0] != bars[0] {
+		t.Errorf("bars.json holds %v, want %v", spec.Bars, bars)
+	}
+}
+
+// TestBundleBars checks that the bars a package lists lie where render
+// painted them on the image it holds, whatever the anchor, orientation
+// and crop.
+func TestBundleBars(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	for _, tt := range []struct {
+		v url.Values
+		o int
+	}{
+		{url.Values{"x": {"100"}, "y": {"50"}, "s": {"0"}}, 0},
+		{url.Values{"x": {"90"}, "y": {"45"}, "s": {"0"}, "anchor": {"10,5"}}, 0},
+		{url.Values{"x": {"100"}, "y": {"50"}, "s": {"0"}, "crop": {"50,20,100,60"}}, 0},
+		{url.Values{"x": {"60"}, "y": {"30"}, "s": {"0"}, "anchor": {"20,10"}, "crop": {"30,10,150,80"}}, 0},
+		{url.Values{"x": {"40"}, "y": {"150"}, "s": {"0"}, "orient": {"original"}}, 6},
+		{url.Values{"x": {"100", "0"}, "y": {"50", "10"}, "s": {"0", "0"}}, 0},
+	} {
+		im := &Image{Orientation: tt.o}
+		dst, err := render(formRequest(tt.v), im, solidImage(200, 100, white))
+		if err != nil {
+			t.Errorf("render(%v): %v", tt.v, err)
+			continue
+		}
+		bars, err := bundleBars(formRequest(tt.v), im, image.Rect(0, 0, 200, 100))
+		if err != nil {
+			t.Errorf("bundleBars(%v): %v", tt.v, err)
+			continue
+		}
+		var painted image.Rectangle
+		b := dst.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if dst.RGBAAt(x, y) != white {
+					painted = painted.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		if len(bars) != 1 {
+			t.Errorf("bundleBars(%v) = %v, want one bar", tt.v, bars)
+			continue
+		}
+		if got := bars[0].Rect(); got != painted {
+			t.Errorf("bundleBars(%v) lists %v, but render painted %v", tt.v, got, painted)
+		}
+	}
+}
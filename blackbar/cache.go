@@ -0,0 +1,173 @@
+package blackbar
+
+// A cache of stored images in memcache, for img. An editor asks for a
+// new rendering with every move of the bar, each of which would
+// otherwise read the image from the datastore and decode it again. The
+// record and its decoded pixels are cached separately, gob-encoded,
+// under its id. Whatever changes a stored image must forget it.
+//
+// Decoded pixels easily exceed what memcache holds under one key, so
+// each value is split into chunks. The key itself holds the number of
+// chunks and a tag, which the keys of the chunks include; a new value
+// gets a new tag, so the chunks of two values are never mixed.
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"image"
+	"strconv"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+	"censor"
+)
+
+// Bounds on the cache.
+const (
+	cacheExpiry    = 10 * time.Minute
+	maxCacheChunk  = 1000 << 10 // bytes; memcache rejects values of 1 MB or more
+	maxCacheChunks = 16
+)
+
+// fetchCached is fetch, answered from memcache if it can be when
+// CacheImages is set.
+func fetchCached(c appengine.Context, id string) (*Image, *datastore.Key, error) {
+	if CacheImages && id != "" {
+		im := new(Image)
+		if cacheGet(c, "image:"+id, im) {
+			return im, datastore.NewKey(c, "Image", id, 0, nil), nil
+		}
+	}
+	im, key, err := fetch(c, id)
+	if err == nil && CacheImages {
+		cacheSet(c, "image:"+id, im)
+	}
+	return im, key, err
+}
+
+// decodeCached returns im, stored under id, decoded, from memcache if
+// it can be when CacheImages is set. The caller may draw on the result.
+func decodeCached(c appengine.Context, id string, im *Image) (image.Image, error) {
+	if CacheImages {
+		m := new(image.RGBA)
+		if cacheGet(c, "pixels:"+id, m) {
+			return m, nil
+		}
+	}
+	m, _, err := image.Decode(bytes.NewReader(im.Data))
+	if err != nil || !CacheImages {
+		return m, err
+	}
+	dst := censor.RGBA(m)
+	cacheSet(c, "pixels:"+id, dst)
+	return dst, nil
+}
+
+// forget drops what the cache holds for the image stored under id. The
+// chunks are left to expire; nothing names them any more.
+func forget(c appengine.Context, id string) {
+	for _, k := range []string{"image:" + id, "pixels:" + id} {
+		if err := memcache.Delete(c, k); err != nil && err != memcache.ErrCacheMiss {
+			c.Warningf("cache: forgetting %s: %v", k, err)
+		}
+	}
+}
+
+// cacheGet decodes the value cached under key into v, reporting whether
+// there was one. Failures of memcache are taken as misses.
+func cacheGet(c appengine.Context, key string, v interface{}) bool {
+	item, err := memcache.Get(c, key)
+	if err != nil {
+		if err != memcache.ErrCacheMiss {
+			c.Warningf("cache: %v", err)
+		}
+		return false
+	}
+	keys, err := chunkKeys(key, string(item.Value))
+	if err != nil {
+		c.Warningf("cache: %s: %v", key, err)
+		return false
+	}
+	items, err := memcache.GetMulti(c, keys)
+	if err != nil {
+		c.Warningf("cache: %v", err)
+		return false
+	}
+	var buf bytes.Buffer
+	for _, k := range keys {
+		item, ok := items[k]
+		if !ok {
+			return false // evicted
+		}
+		buf.Write(item.Value)
+	}
+	return gob.NewDecoder(&buf).Decode(v) == nil
+}
+
+// cacheSet caches v under key, unless it is too large. Failures are
+// logged and otherwise ignored; the cache only saves work.
+func cacheSet(c appengine.Context, key string, v interface{}) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		c.Warningf("cache: encoding %s: %v", key, err)
+		return
+	}
+	head, chunks := splitChunks(key, buf.Bytes(), strconv.FormatInt(time.Now().UnixNano(), 36))
+	if len(chunks) > maxCacheChunks {
+		return
+	}
+	// The chunks go first, so that the key never names missing ones
+	// but for evictions.
+	for _, item := range chunks {
+		item.Expiration = cacheExpiry
+	}
+	err := memcache.SetMulti(c, chunks)
+	if err == nil {
+		err = memcache.Set(c, &memcache.Item{Key: key, Value: []byte(head), Expiration: cacheExpiry})
+	}
+	if err != nil {
+		c.Warningf("cache: %v", err)
+	}
+}
+
+// splitChunks splits data, to be cached under key, into chunks no
+// larger than maxCacheChunk, under keys including tag. It returns them
+// and the value key holds to name them.
+func splitChunks(key string, data []byte, tag string) (string, []*memcache.Item) {
+	var chunks []*memcache.Item
+	for i := 0; len(data) > 0 || i == 0; i++ {
+		n := len(data)
+		if n > maxCacheChunk {
+			n = maxCacheChunk
+		}
+		chunks = append(chunks, &memcache.Item{Key: chunkKey(key, tag, i), Value: data[:n]})
+		data = data[n:]
+	}
+	return fmt.Sprintf("%d %s", len(chunks), tag), chunks
+}
+
+// chunkKeys returns the keys of the chunks named by head, the value
+// cached under key.
+func chunkKeys(key, head string) ([]string, error) {
+	var (
+		n   int
+		tag string
+	)
+	if _, err := fmt.Sscanf(head, "%d %s", &n, &tag); err != nil || n < 1 || n > maxCacheChunks {
+		return nil, fmt.Errorf("bad chunk list %q", head)
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = chunkKey(key, tag, i)
+	}
+	return keys, nil
+}
+
+// chunkKey returns the key of chunk i of the value cached under key
+// with the given tag.
+func chunkKey(key, tag string, i int) string {
+	return fmt.Sprintf("%s/%s/%d", key, tag, i)
+}
@@ -0,0 +1,65 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/gob"
+	"image"
+	"testing"
+)
+
+// TestCacheChunks checks that the decoded pixels of an ordinary photo,
+// too large for one memcache value, are split into chunks that fit and
+// that join up again.
+func TestCacheChunks(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 1024, 768))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 7)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() <= maxCacheChunk {
+		t.Fatalf("encoded %d bytes, expected more than one chunk's worth", buf.Len())
+	}
+
+	head, chunks := splitChunks("pixels:x", buf.Bytes(), "t1")
+	if len(chunks) > maxCacheChunks {
+		t.Fatalf("%d chunks, more than the %d cached", len(chunks), maxCacheChunks)
+	}
+	keys, err := chunkKeys("pixels:x", head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(chunks) {
+		t.Fatalf("head %q names %d chunks, want %d", head, len(keys), len(chunks))
+	}
+	var joined bytes.Buffer
+	for i, item := range chunks {
+		if item.Key != keys[i] {
+			t.Errorf("chunk %d has key %q, head names %q", i, item.Key, keys[i])
+		}
+		if len(item.Value) > maxCacheChunk {
+			t.Errorf("chunk %d is %d bytes, over %d", i, len(item.Value), maxCacheChunk)
+		}
+		joined.Write(item.Value)
+	}
+	got := new(image.RGBA)
+	if err := gob.NewDecoder(&joined).Decode(got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Rect != m.Rect || !bytes.Equal(got.Pix, m.Pix) {
+		t.Error("pixels changed in the cache")
+	}
+
+	// A new value gets new keys.
+	_, again := splitChunks("pixels:x", buf.Bytes(), "t2")
+	if again[0].Key == chunks[0].Key {
+		t.Errorf("values tagged differently share chunk key %q", again[0].Key)
+	}
+	for _, bad := range []string{"", "0 t", "x t", "999 t"} {
+		if _, err := chunkKeys("k", bad); err == nil {
+			t.Errorf("chunkKeys(%q) succeeded, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// parseHexColor parses a color written as hex digits, "rrggbb" or
+// "rrggbbaa", with or without a leading '#'. The alpha defaults to
+// opaque.
+func parseHexColor(s string) (color.RGBA, bool) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 && len(s) != 8 {
+		return color.RGBA{}, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	if len(s) == 6 {
+		v = v<<8 | 0xff
+	}
+	c := color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}
+	return color.RGBAModel.Convert(c).(color.RGBA), true
+}
+
+// checkerSize is the size of the squares of the checkerboard background.
+const checkerSize = 8
+
+// background returns m composited over a background: a gray
+// checkerboard for "checker", which makes transparent areas obvious,
+// or a solid color given in hex. ok is false if bg is neither.
+func background(m *image.RGBA, bg string) (dst *image.RGBA, ok bool) {
+	b := m.Bounds()
+	dst = image.NewRGBA(b)
+	if bg == "checker" {
+		light, dark := image.NewUniform(color.Gray{0xff}), image.NewUniform(color.Gray{0xcc})
+		for y := b.Min.Y; y < b.Max.Y; y += checkerSize {
+			for x := b.Min.X; x < b.Max.X; x += checkerSize {
+				src := light
+				if ((x-b.Min.X)/checkerSize+(y-b.Min.Y)/checkerSize)%2 == 1 {
+					src = dark
+				}
+				draw.Draw(dst, image.Rect(x, y, x+checkerSize, y+checkerSize), src, image.ZP, draw.Src)
+			}
+		}
+	} else if c, ok := parseHexColor(bg); ok {
+		draw.Draw(dst, b, image.NewUniform(c), image.ZP, draw.Src)
+	} else {
+		return nil, false
+	}
+	draw.Draw(dst, b, m, b.Min, draw.Over)
+	return dst, true
+}
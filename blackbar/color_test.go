@@ -0,0 +1,88 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want color.RGBA
+		ok   bool
+	}{
+		{"ff0000", color.RGBA{0xff, 0, 0, 0xff}, true},
+		{"#00ff00", color.RGBA{0, 0xff, 0, 0xff}, true},
+		{"0000ff80", color.RGBA{0, 0, 0x80, 0x80}, true},
+		{"ffffff00", color.RGBA{}, true},
+		{"fff", color.RGBA{}, false},
+		{"gggggg", color.RGBA{}, false},
+		{"", color.RGBA{}, false},
+	} {
+		got, ok := parseHexColor(tt.s)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseHexColor(%q) = %v, %v, want %v, %v", tt.s, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestBackground checks that a checkerboard shows through the
+// transparent half of an image, and a solid color through its
+// half-transparent half, while the opaque parts cover both.
+func TestBackground(t *testing.T) {
+	// Transparent on the left, half-transparent red in the middle,
+	// opaque blue on the right.
+	m := image.NewRGBA(image.Rect(0, 0, 48, 16))
+	for y := 0; y < 16; y++ {
+		for x := 16; x < 32; x++ {
+			m.SetRGBA(x, y, color.RGBA{0x80, 0, 0, 0x80})
+		}
+		for x := 32; x < 48; x++ {
+			m.SetRGBA(x, y, color.RGBA{0, 0, 0xff, 0xff})
+		}
+	}
+	light, dark := color.RGBA{0xff, 0xff, 0xff, 0xff}, color.RGBA{0xcc, 0xcc, 0xcc, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+
+	dst, ok := background(m, "checker")
+	if !ok {
+		t.Fatal("checker not accepted")
+	}
+	for _, tt := range []struct {
+		p    image.Point
+		want color.RGBA
+	}{
+		{image.Pt(0, 0), light},
+		{image.Pt(8, 0), dark},
+		{image.Pt(0, 8), dark},
+		{image.Pt(8, 8), light},
+		{image.Pt(40, 8), blue},
+	} {
+		if got := dst.RGBAAt(tt.p.X, tt.p.Y); got != tt.want {
+			t.Errorf("checker: pixel %v is %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	// Half red over light gray is pink; over dark, darker pink.
+	if a, b := dst.RGBAAt(16, 0), dst.RGBAAt(24, 0); a.R <= a.G || b.R <= b.G || a == b {
+		t.Errorf("checker under half-transparent red: %v and %v, want two pinks", a, b)
+	}
+
+	dst, ok = background(m, "00ff00")
+	if !ok {
+		t.Fatal("00ff00 not accepted")
+	}
+	if got, want := dst.RGBAAt(4, 4), (color.RGBA{0, 0xff, 0, 0xff}); got != want {
+		t.Errorf("solid: transparent pixel is %v, want %v", got, want)
+	}
+	if got := dst.RGBAAt(20, 4); got.R < 0x7f || got.G < 0x7f || got.B != 0 {
+		t.Errorf("solid: half-transparent red over green is %v, want about 80 7f 00", got)
+	}
+	if got := dst.RGBAAt(40, 4); got != blue {
+		t.Errorf("solid: opaque pixel is %v, want %v", got, blue)
+	}
+
+	if _, ok := background(m, "plaid"); ok {
+		t.Error("bg=plaid accepted")
+	}
+}
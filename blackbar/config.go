@@ -0,0 +1,119 @@
+package blackbar
+
+import (
+	"os"
+	"time"
+
+	"censor"
+)
+
+// Configuration. These are variables rather than constants so that a
+// deployment can adjust them from an init function of its own.
+var (
+	// ServePlaceholder makes img answer requests for an id that is not
+	// stored with Placeholder and a 404 status, rather than the error
+	// page, so pages embedding a stale /img link keep their layout.
+	ServePlaceholder = false
+
+	// Placeholder is the image served for missing ids when
+	// ServePlaceholder is set. If nil, a plain "image unavailable"
+	// graphic is generated.
+	Placeholder []byte
+
+	// MaxStrokePoints bounds the number of points in a freehand stroke.
+	MaxStrokePoints = 256
+
+	// MaxOperations bounds the number of form values a render request
+	// may carry. Each asks for more work, so this caps the cost of a
+	// single request.
+	MaxOperations = 64
+
+	// RetainEXIF makes upload keep the EXIF metadata of JPEG uploads,
+	// which exifcaption draws from. It is off by default: re-encoding
+	// strips the metadata, location included, which protects whoever
+	// took the photo.
+	RetainEXIF = false
+
+	// DefaultBars are painted onto every upload, after resizing, for
+	// inputs that always need the same region covered, such as the
+	// timestamp burned into security camera frames. There are none by
+	// default.
+	DefaultBars []censor.Bar
+
+	// TraceOpacity is the opacity, out of 255, of the id that trace
+	// writes across served images. Low values keep the mark faint.
+	TraceOpacity uint8 = 16
+
+	// FrameWorkers bounds the number of animation frames a request
+	// draws at once.
+	FrameWorkers = 4
+
+	// MaxFrames bounds the number of frames in an animated GIF. Every
+	// frame is decoded whole, at upload and whenever the animation is
+	// served, so this caps the memory either takes.
+	MaxFrames = 300
+
+	// ServeDeltas makes img keep recent renderings in memcache and
+	// answer clients that hold one with only the bytes that changed
+	// (see diff.go). It is off by default, as few clients ask.
+	ServeDeltas = false
+
+	// ShutdownTimeout bounds how long Serve waits for requests in
+	// progress to finish once asked to stop.
+	ShutdownTimeout = 30 * time.Second
+
+	// CheckCSRF makes uploading and saving require the token the
+	// upload and edit pages issue, so another site cannot make a
+	// visitor's browser do either (see csrf.go).
+	CheckCSRF = true
+
+	// MaxUploadSize bounds the size of an uploaded file, in bytes.
+	MaxUploadSize int64 = 10 << 20
+
+	// ImageMaxAge is how long browsers and proxies may cache what img
+	// serves before checking that it is still current.
+	ImageMaxAge = 5 * time.Minute
+
+	// MaxDimension is the size, in pixels, above which uploads are
+	// shrunk. An upload wider or taller than MaxDimension is scaled to
+	// fit within half of it; one beyond twice MaxDimension is first
+	// downsampled to MaxDimension, cheaply, so the smoothing pass that
+	// follows never works on an enormous image.
+	MaxDimension = 1200
+
+	// ThumbMaxAge is how long browsers and proxies may cache what thumb
+	// serves. Thumbnails are only previews, so this is long.
+	ThumbMaxAge = 24 * time.Hour
+
+	// UploadRateLimit bounds the number of uploads a client may make in
+	// each UploadRateWindow. Zero or less means no limit.
+	UploadRateLimit  = 30
+	UploadRateWindow = time.Minute
+
+	// CacheImages makes img keep the images it reads, and their decoded
+	// pixels, in memcache for a while (see cache.go).
+	CacheImages = true
+
+	// MaxBatchSize bounds the total size of the images in a batch
+	// upload, in bytes, once uncompressed.
+	MaxBatchSize int64 = 50 << 20
+
+	// CORSOrigins lists the origins, such as "https://app.example.com",
+	// whose pages may call upload, img and the API from scripts; "*"
+	// allows any. There are none by default (see cors.go).
+	CORSOrigins []string
+
+	// ReloadTemplates makes pages parse their templates from disk
+	// anew for every request, so edits to them show without a restart.
+	// It is for development, and is set by the environment variable
+	// BLACKBAR_RELOAD_TEMPLATES.
+	ReloadTemplates = os.Getenv("BLACKBAR_RELOAD_TEMPLATES") != ""
+
+	// SigningKey, if set, is the secret with which URLs naming images
+	// are signed, and handlers then serve only URLs signed with it that
+	// have not expired (see sign.go). It is set by the environment variable
+	// BLACKBAR_SIGNING_KEY. SignedURLLifetime is how long a signed URL
+	// is good for.
+	SigningKey        = os.Getenv("BLACKBAR_SIGNING_KEY")
+	SignedURLLifetime = time.Hour
+)
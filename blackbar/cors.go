@@ -0,0 +1,44 @@
+package blackbar
+
+import (
+	"net/http"
+	"strings"
+)
+
+// cors wraps fn so that pages on the origins listed in CORSOrigins may
+// call it from scripts (Cross-Origin Resource Sharing). Requests from
+// those origins get the Access-Control headers that allow it, and
+// preflight OPTIONS requests are answered here without reaching fn.
+// Requests from other origins are handled as usual, without the
+// headers, so browsers keep their scripts from reading the response.
+func cors(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !allowedOrigin(origin) {
+			fn(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", "ETag, IM, Delta-Base")
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Content-Type, X-CSRF-Token, If-None-Match, A-IM")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// allowedOrigin reports whether CORSOrigins lists origin, or "*".
+func allowedOrigin(origin string) bool {
+	for _, o := range CORSOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
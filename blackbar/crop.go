@@ -0,0 +1,132 @@
+package blackbar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"appengine"
+	"censor"
+)
+
+// cropStored is the HTTP handler for trimming stored images; it handles
+// "/crop". It crops the image stored under id to the rectangle given by
+// the x, y, w and h form values, stores the result as a new image, and
+// opens that in the editor. The original is left as it is. A rectangle
+// reaching past the edges of the image is cut down to fit.
+func cropStored(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "cropping an image requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	rect, err := parseRect(strings.Join([]string{
+		r.FormValue("x"), r.FormValue("y"), r.FormValue("w"), r.FormValue("h"),
+	}, ","))
+	if err != nil {
+		check(badRequest(fmt.Errorf("bad crop: %v", err)))
+	}
+	c := appengine.NewContext(r)
+	im, _, err := fetch(c, r.FormValue("id"))
+	check(err)
+	if im.contentType() == "image/gif" {
+		check(badRequest(errAnimated))
+	}
+	m, _, err := image.Decode(bytes.NewReader(im.Data))
+	check(err)
+	rect = rect.Add(m.Bounds().Min).Intersect(m.Bounds())
+	if rect.Empty() {
+		check(badRequest(errors.New("crop is outside the image")))
+	}
+	dst := censor.RGBA(m)
+	out := cropped(dst, rect)
+	censor.Release(dst)
+	// The crop is upright already, and no longer the shot the camera
+	// took, so it has no orientation to undo.
+	key := put(c, &Image{Exif: im.Exif, ContentType: im.ContentType}, out, 0)
+	http.Redirect(w, r, editURL(key.StringID()), http.StatusFound)
+}
+
+// cropOf returns the rectangle the crop form value of r asks to crop
+// to, within bounds, and whether there is one. Both crop and keep are
+// written as "x,y,w,h". If keep is given it must survive the crop: with
+// keeppolicy=reject a crop that cuts into it is an error; otherwise the
+// crop grows to take it in.
+func cropOf(r *http.Request, bounds image.Rectangle) (image.Rectangle, bool, error) {
+	s := r.FormValue("crop")
+	if s == "" {
+		return image.ZR, false, nil
+	}
+	crop, err := parseRect(s)
+	if err != nil {
+		return image.ZR, false, fmt.Errorf("bad crop: %v", err)
+	}
+	if k := r.FormValue("keep"); k != "" {
+		keep, err := parseRect(k)
+		if err != nil {
+			return image.ZR, false, fmt.Errorf("bad keep: %v", err)
+		}
+		switch p := r.FormValue("keeppolicy"); p {
+		case "", "expand", "reject":
+			if crop, err = safeCrop(crop, keep.Intersect(bounds), p != "reject"); err != nil {
+				return image.ZR, false, err
+			}
+		default:
+			return image.ZR, false, fmt.Errorf("bad keeppolicy %q: want expand or reject", p)
+		}
+	}
+	crop = crop.Intersect(bounds)
+	if crop.Empty() {
+		return image.ZR, false, errors.New("crop is outside the image")
+	}
+	return crop, true, nil
+}
+
+// safeCrop returns crop, making sure it contains keep. If it does not,
+// it is grown to the smallest rectangle that does if expand is set, and
+// rejected otherwise.
+func safeCrop(crop, keep image.Rectangle, expand bool) (image.Rectangle, error) {
+	if keep.Empty() || keep.In(crop) {
+		return crop, nil
+	}
+	if !expand {
+		return image.ZR, fmt.Errorf("crop %v cuts into the region %v that must be kept", crop, keep)
+	}
+	return crop.Union(keep), nil
+}
+
+// cropped returns a copy of the part of m within r, with its origin at
+// the top left.
+func cropped(m *image.RGBA, r image.Rectangle) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), m, r.Min, draw.Src)
+	return dst
+}
+
+// parseRect parses a rectangle written as "x,y,w,h".
+func parseRect(s string) (image.Rectangle, error) {
+	f := strings.Split(s, ",")
+	if len(f) != 4 {
+		return image.ZR, fmt.Errorf("%q is not of the form x,y,w,h", s)
+	}
+	var v [4]int
+	for i := range f {
+		n, err := strconv.Atoi(strings.TrimSpace(f[i]))
+		if err != nil {
+			return image.ZR, err
+		}
+		v[i] = n
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return image.ZR, fmt.Errorf("%q has no area", s)
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
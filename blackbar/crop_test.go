@@ -0,0 +1,83 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"net/url"
+	"testing"
+)
+
+func TestSafeCrop(t *testing.T) {
+	crop := image.Rect(10, 10, 50, 50)
+	for _, tt := range []struct {
+		name   string
+		keep   image.Rectangle
+		expand bool
+		want   image.Rectangle
+		err    bool
+	}{
+		{"inside", image.Rect(20, 20, 30, 30), false, crop, false},
+		{"no keep", image.ZR, false, crop, false},
+		{"grown", image.Rect(40, 40, 60, 70), true, image.Rect(10, 10, 60, 70), false},
+		{"rejected", image.Rect(40, 40, 60, 70), false, image.ZR, true},
+		{"apart", image.Rect(80, 0, 90, 10), true, image.Rect(10, 0, 90, 50), false},
+	} {
+		got, err := safeCrop(crop, tt.keep, tt.expand)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: safeCrop = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRenderCrop checks that render crops to the rectangle asked for,
+// keeping the region that must be kept under either policy.
+func TestRenderCrop(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	for _, tt := range []struct {
+		v    url.Values
+		want image.Rectangle // the part of the image kept
+	}{
+		{url.Values{"crop": {"10,20,50,30"}}, image.Rect(10, 20, 60, 50)},
+		{url.Values{"crop": {"150,50,100,100"}}, image.Rect(150, 50, 200, 100)},
+		{url.Values{"crop": {"10,20,50,30"}, "keep": {"0,0,20,20"}}, image.Rect(0, 0, 60, 50)},
+		{url.Values{"crop": {"10,20,50,30"}, "keep": {"0,0,20,20"}, "keeppolicy": {"expand"}}, image.Rect(0, 0, 60, 50)},
+		{url.Values{"crop": {"10,20,50,30"}, "keep": {"20,25,10,10"}, "keeppolicy": {"reject"}}, image.Rect(10, 20, 60, 50)},
+		// Only the part of keep within the image need be kept.
+		{url.Values{"crop": {"150,50,50,50"}, "keep": {"190,90,50,50"}, "keeppolicy": {"reject"}}, image.Rect(150, 50, 200, 100)},
+	} {
+		m := image.NewRGBA(image.Rect(0, 0, 200, 100))
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 200; x++ {
+				m.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0, 0xff})
+			}
+		}
+		dst, err := render(formRequest(tt.v), &Image{}, m)
+		if err != nil {
+			t.Errorf("render(%v): %v", tt.v, err)
+			continue
+		}
+		if got, want := dst.Bounds(), image.Rect(0, 0, tt.want.Dx(), tt.want.Dy()); got != want {
+			t.Errorf("render(%v): bounds %v, want %v", tt.v, got, want)
+			continue
+		}
+		p := tt.want.Min
+		if got, want := dst.RGBAAt(0, 0), (color.RGBA{uint8(p.X), uint8(p.Y), 0, 0xff}); got != want {
+			t.Errorf("render(%v): top left is %v, want %v", tt.v, got, want)
+		}
+	}
+	for _, v := range []url.Values{
+		{"crop": {"10,20,50,30"}, "keep": {"0,0,20,20"}, "keeppolicy": {"reject"}},
+		{"crop": {"10,20,50,30"}, "keep": {"0,0,20,20"}, "keeppolicy": {"shrink"}},
+		{"crop": {"10,20,50,30"}, "keep": {"0,0,0,20"}},
+		{"crop": {"300,300,10,10"}},
+		{"crop": {"10,20,50"}},
+	} {
+		if _, err := render(formRequest(v), &Image{}, solidImage(200, 100, white)); err == nil {
+			t.Errorf("render(%v) succeeded, want an error", v)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package blackbar
+
+// Protection against cross-site request forgery. Pages that lead to a
+// change, such as the upload form and the editor, set a random token in
+// a cookie and include it in what they send back, as the csrf form value
+// or the X-CSRF-Token header. Another site can make a browser send the
+// cookie, but cannot read it to send the token too.
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+// csrfCookie is the name of the cookie holding the token.
+const csrfCookie = "csrf"
+
+// csrfToken returns the token of the client making r, issuing a new one
+// in a cookie if it has none.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	check(err)
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: token, Path: "/", HttpOnly: true})
+	return token
+}
+
+// checkCSRF reports whether r carries the token of its client, replying
+// with 403 Forbidden if not. It always succeeds if CheckCSRF is unset.
+func checkCSRF(w http.ResponseWriter, r *http.Request) bool {
+	if !CheckCSRF {
+		return true
+	}
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.FormValue("csrf")
+	}
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(token)) != 1 {
+		http.Error(w, "missing or invalid CSRF token", http.StatusForbidden)
+		return false
+	}
+	return true
+}
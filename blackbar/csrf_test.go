@@ -0,0 +1,70 @@
+package blackbar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCheckCSRF checks that a token is accepted as a form value or a
+// header when it matches the cookie, and refused otherwise.
+func TestCheckCSRF(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		cookie, header string
+		form           string
+		ok             bool
+	}{
+		{"form", "tok", "", "csrf=tok", true},
+		{"header", "tok", "tok", "", true},
+		{"header over form", "tok", "tok", "csrf=bad", true},
+		{"missing", "tok", "", "", false},
+		{"wrong", "tok", "", "csrf=tak", false},
+		{"no cookie", "", "", "csrf=tok", false},
+		{"empty", "", "", "csrf=", false},
+	} {
+		r := httptest.NewRequest("POST", "/delete", strings.NewReader(tt.form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			r.Header.Set("X-CSRF-Token", tt.header)
+		}
+		w := httptest.NewRecorder()
+		if got := checkCSRF(w, r); got != tt.ok {
+			t.Errorf("%s: checkCSRF = %v, want %v", tt.name, got, tt.ok)
+		}
+		if !tt.ok && w.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+// TestCSRFToken checks that a client without a token is issued one in a
+// cookie, and a client with one keeps it.
+func TestCSRFToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	token := csrfToken(w, httptest.NewRequest("GET", "/", nil))
+	if len(token) != 32 {
+		t.Errorf("token %q, want 32 hex digits", token)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || cookies[0].Value != token || !cookies[0].HttpOnly {
+		t.Errorf("cookies %v, want an HttpOnly %s cookie holding %q", cookies, csrfCookie, token)
+	}
+	if other := csrfToken(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); other == token {
+		t.Error("two clients issued the same token")
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "kept"})
+	w = httptest.NewRecorder()
+	if got := csrfToken(w, r); got != "kept" {
+		t.Errorf("client's token replaced by %q", got)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("cookie set for a client that has one")
+	}
+}
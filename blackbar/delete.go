@@ -0,0 +1,49 @@
+package blackbar
+
+import (
+	"net/http"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// remove is the HTTP handler for deleting images; it handles "/delete".
+// It deletes the image stored under id, then returns to the list, or to
+// the front page while URLs are signed.
+func remove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "deleting an image requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	c := appengine.NewContext(r)
+	id := r.FormValue("id")
+	key := datastore.NewKey(c, "Image", id, 0, nil)
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		// Delete succeeds whether or not there is anything to delete,
+		// so look first, to tell the user if there was not.
+		if err := datastore.Get(c, key, new(Image)); err != nil {
+			return err
+		}
+		return datastore.Delete(c, key)
+	}, nil)
+	if err == datastore.ErrNoSuchEntity {
+		errorPage(w, http.StatusNotFound, "There is no image "+id+"; it may have been deleted already.")
+		return
+	}
+	check(err)
+	forget(c, id)
+	http.Redirect(w, r, afterDelete(), http.StatusFound)
+}
+
+// afterDelete returns the page remove returns to: the list, unless URLs
+// are signed, which turns it off (see unlisted), and the front page then.
+func afterDelete() string {
+	if SigningKey != "" {
+		return "/"
+	}
+	return "/list"
+}
@@ -0,0 +1,28 @@
+package blackbar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAfterDelete checks that deleting an image returns to a page the
+// user may see: the list, unless URLs are signed, which turns it off.
+func TestAfterDelete(t *testing.T) {
+	defer func(k string) { SigningKey = k }(SigningKey)
+	for _, tt := range []struct{ key, want string }{{"", "/list"}, {"secret", "/"}} {
+		SigningKey = tt.key
+		if got := afterDelete(); got != tt.want {
+			t.Errorf("signing key %q: returns to %q, want %q", tt.key, got, tt.want)
+			continue
+		}
+		if tt.want != "/list" {
+			continue
+		}
+		w := httptest.NewRecorder()
+		unlisted(func(w http.ResponseWriter, r *http.Request) {})(w, httptest.NewRequest("GET", "/list", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("signing key %q: the list answers %d", tt.key, w.Code)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package blackbar
+
+// Delta encoding (RFC 3229) of rendered images. The editor asks for a
+// new rendering with every adjustment of a bar, and most of each one is
+// the same as the last. A client that names the last rendering it got
+// in If-None-Match, and accepts the splice manipulation in A-IM, gets
+// only the bytes that changed.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/memcache"
+)
+
+// spliceIM names the instance manipulation splice computes.
+const spliceIM = "x-blackbar-splice"
+
+// Bounds on the renderings kept as bases for later deltas.
+const (
+	maxDeltaBase    = 1 << 20 // bytes; memcache rejects larger values
+	deltaBaseExpiry = 10 * time.Minute
+)
+
+// renditionTag returns the entity tag for rendering im as r asks. The
+// stored data and the form values determine the rendering completely,
+// so the tag can be known, and checked, before doing any of the work.
+func renditionTag(im *Image, r *http.Request) string {
+	r.ParseForm()
+	h := sha1.New()
+	h.Write(im.Data)
+	io.WriteString(h, r.Form.Encode()) // sorted by key
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// notModified reports whether the client making r already has the
+// rendering tagged etag, replying 304 Not Modified if so.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if r.Header.Get("If-None-Match") != etag {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// writeRendition writes out, a rendering of content type ctype, to w,
+// with the entity tag etag, if any, identifying it. A request whose
+// If-None-Match names an earlier rendering still in memcache, and that
+// accepts spliceIM, gets a delta from it with status 226 IM Used, if
+// ServeDeltas is set.
+func writeRendition(c appengine.Context, w http.ResponseWriter, r *http.Request, ctype string, out []byte, etag string) {
+	w.Header().Set("Content-type", ctype)
+	if etag == "" {
+		w.Write(out)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if !ServeDeltas {
+		w.Write(out)
+		return
+	}
+	if len(out) <= maxDeltaBase {
+		err := memcache.Set(c, &memcache.Item{Key: "rendition:" + etag, Value: out, Expiration: deltaBaseExpiry})
+		if err != nil {
+			c.Warningf("storing delta base: %v", err)
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && strings.Contains(r.Header.Get("A-IM"), spliceIM) {
+		for _, base := range strings.Split(inm, ",") {
+			base = strings.TrimSpace(base)
+			item, err := memcache.Get(c, "rendition:"+base)
+			if err != nil {
+				continue
+			}
+			w.Header().Set("IM", spliceIM)
+			w.Header().Set("Delta-Base", base)
+			w.WriteHeader(226) // IM Used
+			w.Write(splice(item.Value, out))
+			return
+		}
+	}
+	w.Write(out)
+}
+
+// splice returns a delta that turns base into next. It is the length of
+// their common prefix and of their common suffix, as uvarints, followed
+// by the bytes of next between the two.
+func splice(base, next []byte) []byte {
+	p := 0
+	for p < len(base) && p < len(next) && base[p] == next[p] {
+		p++
+	}
+	s := 0
+	for s < len(base)-p && s < len(next)-p && base[len(base)-1-s] == next[len(next)-1-s] {
+		s++
+	}
+	var buf bytes.Buffer
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(p))])
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(s))])
+	buf.Write(next[p : len(next)-s])
+	return buf.Bytes()
+}
@@ -0,0 +1,84 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// applySplice applies a delta made by splice to base, as the editor
+// does.
+func applySplice(t *testing.T, base, delta []byte) []byte {
+	r := bytes.NewReader(delta)
+	p, err := binary.ReadUvarint(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := binary.ReadUvarint(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mid := delta[len(delta)-r.Len():]
+	out := append([]byte{}, base[:p]...)
+	out = append(out, mid...)
+	return append(out, base[len(base)-int(s):]...)
+}
+
+// TestSplice checks that a delta between two renderings of a moved bar
+// rebuilds the second from the first, and is smaller than it.
+func TestSplice(t *testing.T) {
+	m := solidImage(300, 200, color.RGBA{0x20, 0x60, 0xa0, 0xff})
+	rendering := func(x string) []byte {
+		dst, err := render(formRequest(url.Values{"x": {x}, "y": {"150"}}), &Image{}, cloneRGBA(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encodePNG(t, dst)
+	}
+	base, next := rendering("100"), rendering("110")
+	delta := splice(base, next)
+	if got := applySplice(t, base, delta); !bytes.Equal(got, next) {
+		t.Error("delta does not rebuild the new rendering")
+	}
+	if len(delta) >= len(next) {
+		t.Errorf("delta is %d bytes, no smaller than the %d of the rendering", len(delta), len(next))
+	}
+	for _, tt := range [][2]string{{"", ""}, {"abc", "abc"}, {"abc", ""}, {"", "abc"}, {"aXa", "aYYa"}, {"aaaa", "aa"}} {
+		if got := applySplice(t, []byte(tt[0]), splice([]byte(tt[0]), []byte(tt[1]))); string(got) != tt[1] {
+			t.Errorf("splice(%q, %q) rebuilds %q", tt[0], tt[1], got)
+		}
+	}
+}
+
+// cloneRGBA returns a copy of m.
+func cloneRGBA(m *image.RGBA) *image.RGBA {
+	c := image.NewRGBA(m.Bounds())
+	copy(c.Pix, m.Pix)
+	return c
+}
+
+// TestWriteRenditionFull checks that, without deltas, the rendering is
+// served whole with its tag, and that a client holding it gets 304.
+func TestWriteRenditionFull(t *testing.T) {
+	defer func(b bool) { ServeDeltas = b }(ServeDeltas)
+	ServeDeltas = false
+	r := httptest.NewRequest("GET", "/img?id=x", nil)
+	r.Header.Set("If-None-Match", `"old"`)
+	r.Header.Set("A-IM", spliceIM)
+	w := httptest.NewRecorder()
+	writeRendition(nil, w, r, "image/png", []byte("rendering"), `"new"`)
+	if w.Code != http.StatusOK || w.Body.String() != "rendering" || w.Header().Get("ETag") != `"new"` {
+		t.Errorf("got %d %q with ETag %q, want the whole rendering", w.Code, w.Body, w.Header().Get("ETag"))
+	}
+
+	w = httptest.NewRecorder()
+	r.Header.Set("If-None-Match", `"new"`)
+	if !notModified(w, r, `"new"`) || w.Code != http.StatusNotModified {
+		t.Errorf("client with the rendering got %d, want %d", w.Code, http.StatusNotModified)
+	}
+}
@@ -0,0 +1,236 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// exifInfo is the little of a photo's EXIF metadata that we use.
+type exifInfo struct {
+	Orientation int    // 1 to 8, or 0 if not recorded
+	Time        string // capture time, as "YYYY:MM:DD HH:MM:SS"
+	GPS         bool   // whether Lat and Lon are set
+	Lat, Lon    float64
+}
+
+// EXIF tags we look for.
+const (
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+)
+
+// exifSegment returns the EXIF payload (a TIFF structure) of JPEG data,
+// or nil if it has none.
+func exifSegment(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 { // start of scan, end of image
+			break
+		}
+		n := int(data[i+2])<<8 | int(data[i+3])
+		if n < 2 || i+2+n > len(data) {
+			break
+		}
+		seg := data[i+4 : i+2+n]
+		if marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:]
+		}
+		i += 2 + n
+	}
+	return nil
+}
+
+// parseExif decodes the parts of an EXIF payload listed in exifInfo.
+func parseExif(tiff []byte) (*exifInfo, error) {
+	if len(tiff) < 8 {
+		return nil, errors.New("exif: short header")
+	}
+	t := tiffReader{b: tiff}
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil, errors.New("exif: bad TIFF header")
+	}
+	ifd0, err := t.ifd(t.order.Uint32(tiff[4:]))
+	if err != nil {
+		return nil, err
+	}
+	x := new(exifInfo)
+	if e, ok := ifd0[tagOrientation]; ok {
+		x.Orientation = int(e.uint(t.order))
+	}
+	if e, ok := ifd0[tagDateTime]; ok {
+		x.Time = e.string()
+	}
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := t.ifd(e.uint(t.order)); err == nil {
+			if e, ok := sub[tagDateTimeOriginal]; ok {
+				x.Time = e.string()
+			}
+		}
+	}
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := t.ifd(e.uint(t.order)); err == nil {
+			lat, lok := gps[tagGPSLatitude]
+			lon, nok := gps[tagGPSLongitude]
+			if lok && nok {
+				x.Lat, lok = degrees(lat.rationals(t.order), gps[tagGPSLatitudeRef].string() == "S")
+				x.Lon, nok = degrees(lon.rationals(t.order), gps[tagGPSLongitudeRef].string() == "W")
+				x.GPS = lok && nok
+			}
+		}
+	}
+	return x, nil
+}
+
+// degrees converts degrees, minutes and seconds to signed decimal
+// degrees.
+func degrees(dms []float64, negative bool) (float64, bool) {
+	if len(dms) != 3 {
+		return 0, false
+	}
+	d := dms[0] + dms[1]/60 + dms[2]/3600
+	if negative {
+		d = -d
+	}
+	return d, true
+}
+
+// tiffReader reads the image file directories of a TIFF structure.
+type tiffReader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// tiffEntry is one field of an image file directory.
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// tiffTypeSize gives the size of each TIFF field type, by type number.
+var tiffTypeSize = [...]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// ifd reads the directory at offset off.
+func (t tiffReader) ifd(off uint32) (map[uint16]tiffEntry, error) {
+	if uint64(off)+2 > uint64(len(t.b)) {
+		return nil, errors.New("exif: directory out of range")
+	}
+	n := int(t.order.Uint16(t.b[off:]))
+	if uint64(off)+2+12*uint64(n) > uint64(len(t.b)) {
+		return nil, errors.New("exif: directory out of range")
+	}
+	fields := make(map[uint16]tiffEntry, n)
+	for i := 0; i < n; i++ {
+		f := t.b[off+2+12*uint32(i):]
+		e := tiffEntry{typ: t.order.Uint16(f[2:]), count: t.order.Uint32(f[4:])}
+		if int(e.typ) >= len(tiffTypeSize) || tiffTypeSize[e.typ] == 0 {
+			continue
+		}
+		size := uint64(tiffTypeSize[e.typ]) * uint64(e.count)
+		if size <= 4 {
+			e.value = f[8 : 8+size]
+		} else {
+			at := uint64(t.order.Uint32(f[8:]))
+			if at+size > uint64(len(t.b)) {
+				continue
+			}
+			e.value = t.b[at : at+size]
+		}
+		fields[t.order.Uint16(f)] = e
+	}
+	return fields, nil
+}
+
+// uint returns the first value of a SHORT or LONG field.
+func (e tiffEntry) uint(order binary.ByteOrder) uint32 {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(order.Uint16(e.value))
+	case e.typ == 4 && len(e.value) >= 4:
+		return order.Uint32(e.value)
+	}
+	return 0
+}
+
+// string returns the value of an ASCII field.
+func (e tiffEntry) string() string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(e.value), "\x00 ")
+}
+
+// rationals returns the values of a RATIONAL field.
+func (e tiffEntry) rationals(order binary.ByteOrder) []float64 {
+	if e.typ != 5 {
+		return nil
+	}
+	var v []float64
+	for b := e.value; len(b) >= 8; b = b[8:] {
+		num, den := order.Uint32(b), order.Uint32(b[4:])
+		if den == 0 {
+			return nil
+		}
+		v = append(v, float64(num)/float64(den))
+	}
+	return v
+}
+
+// exifCaption draws the capture time and location recorded in the EXIF
+// payload tiff as a caption along the bottom of m. It does nothing if
+// neither is recorded.
+func exifCaption(m *image.RGBA, tiff []byte) {
+	x, err := parseExif(tiff)
+	if err != nil {
+		return
+	}
+	var parts []string
+	if x.Time != "" {
+		parts = append(parts, x.Time)
+	}
+	if x.GPS {
+		ns, ew := "N", "E"
+		if x.Lat < 0 {
+			ns = "S"
+		}
+		if x.Lon < 0 {
+			ew = "W"
+		}
+		parts = append(parts, fmt.Sprintf("%.5f %s, %.5f %s", abs64(x.Lat), ns, abs64(x.Lon), ew))
+	}
+	if len(parts) == 0 {
+		return
+	}
+	b := m.Bounds()
+	band := image.Rect(b.Min.X, b.Max.Y-textHeight-4, b.Max.X, b.Max.Y)
+	draw.Draw(m, band, image.NewUniform(color.Black), image.ZP, draw.Src)
+	drawText(m, image.Pt(band.Min.X+4, band.Max.Y-2-textDescent), strings.Join(parts, "   "), color.White)
+}
+
+func abs64(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
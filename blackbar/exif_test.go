@@ -0,0 +1,134 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// gpsExif returns an EXIF payload, big-endian, recording orientation o,
+// a capture time, and a location south and west: 33°51'35.4" S,
+// 151°12'40.2" W.
+func gpsExif(o int) []byte {
+	var b bytes.Buffer
+	be := binary.BigEndian
+	u16 := func(v uint16) { binary.Write(&b, be, v) }
+	u32 := func(v uint32) { binary.Write(&b, be, v) }
+	entry := func(tag, typ uint16, count, value uint32) {
+		u16(tag)
+		u16(typ)
+		u32(count)
+		u32(value)
+	}
+	ascii := func(s string) uint32 { // an ASCII value of up to 3 bytes, in place
+		v := make([]byte, 4)
+		copy(v, s)
+		return be.Uint32(v)
+	}
+	const (
+		ifd0  = 8
+		date  = ifd0 + 2 + 3*12 + 4
+		gps   = date + 20
+		lat   = gps + 2 + 4*12 + 4
+		lon   = lat + 24
+		total = lon + 24
+	)
+	b.WriteString("MM\x00*")
+	u32(ifd0)
+	u16(3)
+	entry(tagOrientation, 3, 1, uint32(o)<<16)
+	entry(tagDateTime, 2, 20, date)
+	entry(tagGPSIFD, 4, 1, gps)
+	u32(0)
+	b.WriteString("2012:06:01 12:34:56\x00")
+	u16(4)
+	entry(tagGPSLatitudeRef, 2, 2, ascii("S"))
+	entry(tagGPSLatitude, 5, 3, lat)
+	entry(tagGPSLongitudeRef, 2, 2, ascii("W"))
+	entry(tagGPSLongitude, 5, 3, lon)
+	u32(0)
+	for _, v := range []uint32{33, 1, 51, 1, 354, 10, 151, 1, 12, 1, 402, 10} {
+		u32(v)
+	}
+	if b.Len() != total {
+		panic("gpsExif: layout is off")
+	}
+	return b.Bytes()
+}
+
+// withExif returns a small JPEG carrying the EXIF payload tiff.
+func withExif(t *testing.T, tiff []byte) []byte {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	jpg := b.Bytes()
+	n := 2 + 6 + len(tiff)
+	out := append([]byte{0xff, 0xd8, 0xff, 0xe1, byte(n >> 8), byte(n)}, "Exif\x00\x00"...)
+	out = append(out, tiff...)
+	return append(out, jpg[2:]...)
+}
+
+func TestParseExif(t *testing.T) {
+	tiff := exifSegment(withExif(t, gpsExif(6)))
+	if tiff == nil {
+		t.Fatal("no EXIF found in JPEG")
+	}
+	x, err := parseExif(tiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Orientation != 6 {
+		t.Errorf("orientation %d, want 6", x.Orientation)
+	}
+	if x.Time != "2012:06:01 12:34:56" {
+		t.Errorf("time %q, want 2012:06:01 12:34:56", x.Time)
+	}
+	wantLat, wantLon := -(33 + 51/60.0 + 35.4/3600), -(151 + 12/60.0 + 40.2/3600)
+	if !x.GPS || math.Abs(x.Lat-wantLat) > 1e-9 || math.Abs(x.Lon-wantLon) > 1e-9 {
+		t.Errorf("location %v, %v (GPS %v), want %v, %v", x.Lat, x.Lon, x.GPS, wantLat, wantLon)
+	}
+	if exifSegment([]byte("not a JPEG")) != nil {
+		t.Error("EXIF found in something not a JPEG")
+	}
+	for _, bad := range [][]byte{nil, []byte("MM\x00*"), []byte("XX\x00*\x00\x00\x00\x08"), []byte("MM\x00*\x00\x00\xff\xff")} {
+		if _, err := parseExif(bad); err == nil {
+			t.Errorf("parseExif(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+// TestExifCaption checks that a caption is drawn along the bottom of a
+// photo with EXIF, and nothing is drawn on one without.
+func TestExifCaption(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	m := solidImage(400, 100, white)
+	exifCaption(m, gpsExif(1))
+	if m.RGBAAt(399, 99) == white {
+		t.Error("no caption band along the bottom")
+	}
+	if m.RGBAAt(200, 10) != white {
+		t.Error("caption drawn above the bottom")
+	}
+	text := 0
+	for x := 0; x < 400; x++ {
+		if m.RGBAAt(x, 99-textDescent-4) == white {
+			text++
+		}
+	}
+	if text == 0 {
+		t.Error("caption band holds no text")
+	}
+
+	m = solidImage(400, 100, white)
+	exifCaption(m, nil)
+	for i, p := range m.Pix {
+		if p != 0xff {
+			t.Fatalf("byte %d of an image without EXIF changed", i)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package blackbar
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/url"
+
+	"appengine"
+	"censor"
+	"resize"
+)
+
+// maxExportSize bounds the size of the file served by export.
+const maxExportSize = 32 << 20
+
+// exportThumbSize is the largest width or height of the thumbnail in an
+// OpenRaster file, as the format requires.
+const exportThumbSize = 256
+
+// oraStack is the stack.xml of an OpenRaster file.
+type oraStack struct {
+	XMLName xml.Name   `xml:"image"`
+	Version string     `xml:"version,attr"`
+	Width   int        `xml:"w,attr"`
+	Height  int        `xml:"h,attr"`
+	Layers  []oraLayer `xml:"stack>layer"`
+}
+
+// An oraLayer is a layer of an OpenRaster file, placed with its top left
+// at (X, Y).
+type oraLayer struct {
+	Name string `xml:"name,attr"`
+	Src  string `xml:"src,attr"`
+	X    int    `xml:"x,attr"`
+	Y    int    `xml:"y,attr"`
+}
+
+// export is the HTTP handler for downloading an image with its bars as
+// separate layers, for further work in an image editor; it handles
+// "/export". The format form value must be ora, for OpenRaster, which
+// is also the default. The bottom layer is the image as uploaded, and
+// above it, in the order they were saved, is a layer for each bar of
+// each saved edit (see undo.go), holding the pixels the bar changed.
+// What an edit does other than paint bars, such as a filter, is in no
+// layer; an edit that changes the size of the image cannot be exported.
+func export(w http.ResponseWriter, r *http.Request) {
+	if f := r.FormValue("format"); f != "" && f != "ora" {
+		check(badRequest(fmt.Errorf("bad format %q: want ora", f)))
+	}
+	c := appengine.NewContext(r)
+	im, key, err := fetch(c, r.FormValue("id"))
+	check(err)
+	if im.contentType() == "image/gif" {
+		check(badRequest(errors.New("animated images cannot be exported")))
+	}
+	orig := im.Original
+	if orig == nil {
+		orig = im.Data
+	}
+	base, _, err := image.Decode(bytes.NewReader(orig))
+	check(err)
+	m := censor.RGBA(base)
+	b := m.Bounds()
+
+	var (
+		buf    bytes.Buffer
+		zw     = zip.NewWriter(&buf)
+		stack  = oraStack{Version: "0.0.5", Width: b.Dx(), Height: b.Dy()}
+		layers []oraLayer // bottom first
+	)
+	add := func(name string, data []byte, method uint16) {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		check(err)
+		_, err = f.Write(data)
+		check(err)
+	}
+	addPNG := func(name string, m image.Image) {
+		var b bytes.Buffer
+		check(png.Encode(&b, m))
+		add(name, b.Bytes(), zip.Store) // compressed already
+	}
+
+	// The mimetype must come first, uncompressed, so that the file
+	// can be recognized by its first bytes.
+	add("mimetype", []byte("image/openraster"), zip.Store)
+	addPNG("data/original.png", m)
+	layers = append(layers, oraLayer{"Original", "data/original.png", 0, 0})
+	for i, e := range im.Edits {
+		form, err := url.ParseQuery(e)
+		check(err)
+		req := &http.Request{Form: form}
+		anchor, err := anchorOf(req)
+		check(err)
+		bars, err := barsOf(req)
+		check(err)
+		check(placeBars(req, im, bars, anchor, b))
+		// render paints over m, so keep a copy to compare with.
+		prev := image.NewRGBA(b)
+		draw.Draw(prev, b, m, b.Min, draw.Src)
+		next, err := render(req, im, m)
+		check(err)
+		if next.Bounds() != b {
+			check(badRequest(fmt.Errorf("edit %d changes the size of the image, so it cannot be exported in layers", i+1)))
+		}
+		for j, bar := range bars {
+			if bar.X <= 0 {
+				continue
+			}
+			l := changed(prev, next, bar.Bounds().Intersect(b))
+			if l == nil {
+				continue
+			}
+			name := fmt.Sprintf("data/edit-%d-bar-%d.png", i+1, j+1)
+			addPNG(name, l)
+			lb := l.Bounds()
+			layers = append(layers, oraLayer{fmt.Sprintf("Edit %d, bar %d", i+1, j+1), name, lb.Min.X - b.Min.X, lb.Min.Y - b.Min.Y})
+		}
+		m = next
+	}
+	addPNG("mergedimage.png", m)
+	thumb := image.Image(m)
+	if b.Dx() > exportThumbSize || b.Dy() > exportThumbSize {
+		tw, th := censor.FitWithin(b, exportThumbSize, exportThumbSize)
+		thumb = resize.Resize(m, b, tw, th)
+	}
+	addPNG("Thumbnails/thumbnail.png", thumb)
+	// stack.xml lists the layers top first.
+	for i := len(layers) - 1; i >= 0; i-- {
+		stack.Layers = append(stack.Layers, layers[i])
+	}
+	data, err := xml.MarshalIndent(stack, "", "\t")
+	check(err)
+	add("stack.xml", append([]byte(xml.Header), data...), zip.Deflate)
+	check(zw.Close())
+
+	if buf.Len() > maxExportSize {
+		check(fmt.Errorf("export is %d bytes, over the limit of %d", buf.Len(), maxExportSize))
+	}
+	w.Header().Set("Content-type", "image/openraster")
+	w.Header().Set("Content-disposition", fmt.Sprintf("attachment; filename=%q", key.StringID()+".ora"))
+	w.Write(buf.Bytes())
+}
+
+// changed returns the pixels of after, within r, that differ from those
+// of before, transparent elsewhere, or nil if none do.
+func changed(before, after *image.RGBA, r image.Rectangle) *image.RGBA {
+	if r.Empty() {
+		return nil
+	}
+	l := image.NewRGBA(r)
+	found := false
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p, q := before.Pix[before.PixOffset(x, y):][:4], after.Pix[after.PixOffset(x, y):][:4]
+			if !bytes.Equal(p, q) {
+				copy(l.Pix[l.PixOffset(x, y):], q)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	return l
+}
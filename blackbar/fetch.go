@@ -0,0 +1,47 @@
+package blackbar
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+// fetchURL returns the image at the http or https URL u, for uploading
+// by URL. Failures the user can fix, such as a URL that is not an image,
+// are marked with badRequest.
+func fetchURL(c appengine.Context, u string) ([]byte, error) {
+	if p, err := url.Parse(u); err != nil || p.Scheme != "http" && p.Scheme != "https" {
+		return nil, badRequest(fmt.Errorf("%q is not an http or https URL", u))
+	}
+	resp, err := urlfetch.Client(c).Get(u)
+	if err != nil {
+		return nil, badRequest(fmt.Errorf("fetching %s: %v", u, err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, badRequest(fmt.Errorf("fetching %s: %s", u, resp.Status))
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "image/") {
+		return nil, badRequest(fmt.Errorf("%s is not an image but %q", u, ct))
+	}
+	if resp.ContentLength > MaxUploadSize {
+		return nil, badRequest(errTooLarge)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxUploadSize+1))
+	if err != nil {
+		return nil, badRequest(fmt.Errorf("fetching %s: %v", u, err))
+	}
+	if int64(len(data)) > MaxUploadSize {
+		return nil, badRequest(errTooLarge)
+	}
+	return data, nil
+}
+
+// errTooLarge reports an image larger than MaxUploadSize.
+var errTooLarge = errors.New("the image is too large")
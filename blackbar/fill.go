@@ -0,0 +1,129 @@
+package blackbar
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"censor"
+	"resize"
+)
+
+// fillOf returns the image a bar is painted with, as selected by the
+// fill form value of r; the default is solid color c (see colorOf),
+// black unless given. m is the image
+// being barred, and the fill shares its coordinate space.
+//
+//	fill=sample&sample=px,py  the color of m at (px, py)
+//	fill=noise&seed=n         gray noise
+//	fill=text&text=CENSORED   the text repeated over black, across
+//	                          the bars and boxes
+//
+// Noise is drawn from a generator seeded with seed, or with 1 if seed is
+// absent, so the same request always renders the same bytes; it is never
+// seeded from the clock.
+func fillOf(r *http.Request, m image.Image) (image.Image, error) {
+	switch r.FormValue("fill") {
+	case "text":
+		text, err := textOf(r)
+		if err != nil {
+			return nil, err
+		}
+		if text == "" {
+			text = "CENSORED"
+		}
+		bars, err := barsOf(r)
+		if err != nil {
+			return nil, err
+		}
+		boxes, err := boxesOf(r, m.Bounds().Size())
+		if err != nil {
+			return nil, err
+		}
+		var cover image.Rectangle
+		for _, b := range bars {
+			if b.X > 0 {
+				clampSize(&b, m.Bounds())
+				cover = cover.Union(b.Bounds())
+			}
+		}
+		for _, br := range boxes {
+			cover = cover.Union(br.Add(m.Bounds().Min))
+		}
+		return tiledText(cover, text), nil
+	case "noise":
+		seed := int64(1)
+		if s := r.FormValue("seed"); s != "" {
+			var err error
+			if seed, err = strconv.ParseInt(s, 10, 64); err != nil {
+				return nil, fmt.Errorf("bad seed: %v", err)
+			}
+		}
+		n := image.NewGray(m.Bounds())
+		rand.New(rand.NewSource(seed)).Read(n.Pix)
+		return n, nil
+	case "sample":
+		p, err := parsePoint(r.FormValue("sample"))
+		if err != nil {
+			return nil, fmt.Errorf("bad sample point: %v", err)
+		}
+		if !p.In(m.Bounds()) {
+			return nil, fmt.Errorf("sample point %v is outside the image %v", p, m.Bounds())
+		}
+		return image.NewUniform(m.At(p.X, p.Y)), nil
+	}
+	return image.NewUniform(colorOf(r)), nil
+}
+
+// colorOf returns the bar color given in hex by the c form value of r,
+// such as ff0000 for red or 00ff0080 for translucent green. It is black
+// if c is absent or malformed.
+func colorOf(r *http.Request) color.Color {
+	if c, ok := parseHexColor(r.FormValue("c")); ok {
+		return c
+	}
+	return color.Black
+}
+
+// tiledText returns an image covering r with text repeated in white on
+// black, row after row. The text is scaled up to fill about two rows,
+// and alternate rows are offset like courses of bricks.
+func tiledText(r image.Rectangle, text string) image.Image {
+	const lineHeight = textHeight + 2
+	tile := textTile(text+" ", lineHeight, color.White, color.Black)
+	if k := r.Dy() / (2 * lineHeight); k > 1 {
+		tile = censor.RGBA(resize.Resample(tile, tile.Bounds(), k*tile.Bounds().Dx(), k*tile.Bounds().Dy()))
+	}
+
+	m := image.NewRGBA(r)
+	tw, th := tile.Bounds().Dx(), tile.Bounds().Dy()
+	for row, y := 0, r.Min.Y; y < r.Max.Y; row, y = row+1, y+th {
+		x := r.Min.X - (row%2)*tw/2
+		for ; x < r.Max.X; x += tw {
+			draw.Draw(m, image.Rect(x, y, x+tw, y+th), tile, image.ZP, draw.Src)
+		}
+	}
+	return m
+}
+
+// parsePoint parses a point written as "x,y".
+func parsePoint(s string) (image.Point, error) {
+	xy := strings.Split(s, ",")
+	if len(xy) != 2 {
+		return image.ZP, fmt.Errorf("%q is not of the form x,y", s)
+	}
+	x, err := strconv.Atoi(strings.TrimSpace(xy[0]))
+	if err != nil {
+		return image.ZP, err
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(xy[1]))
+	if err != nil {
+		return image.ZP, err
+	}
+	return image.Pt(x, y), nil
+}
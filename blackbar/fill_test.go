@@ -0,0 +1,144 @@
+package blackbar
+
+import (
+	"image/color"
+	"math"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestTextFillSpansBar checks that fill=text covers the bar from edge
+// to edge, with text in it, not only background.
+func TestTextFillSpansBar(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	v := url.Values{"x": {"150"}, "y": {"50"}, "s": {"3"}, "fill": {"text"}}
+	dst, err := render(formRequest(v), &Image{}, solidImage(300, 100, red))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bar is 200 by 40, from (50, 30) to (250, 70).
+	for x := 50; x < 250; x++ {
+		if dst.RGBAAt(x, 50) == red {
+			t.Fatalf("pixel (%d, 50) of bar left unpainted", x)
+		}
+	}
+	var white int
+	for y := 30; y < 70; y++ {
+		for x := 50; x < 250; x++ {
+			if c := dst.RGBAAt(x, y); c.R == 0xff && c.G == 0xff && c.B == 0xff {
+				white++
+			}
+		}
+	}
+	if white == 0 {
+		t.Error("bar holds no text")
+	}
+	if dst.RGBAAt(20, 50) != red || dst.RGBAAt(150, 10) != red {
+		t.Error("text fill spilled outside the bar")
+	}
+}
+
+// TestTextFillRotated checks that fill=text covers a rotated bar,
+// corners included.
+func TestTextFillRotated(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	const cx, cy, a = 150, 150, 45.0
+	v := url.Values{"x": {"150"}, "y": {"150"}, "s": {"2"}, "a": {"45"}, "fill": {"text"}}
+	dst, err := render(formRequest(v), &Image{}, solidImage(300, 300, red))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bar is 150 by 30; check every pixel well within it.
+	hw, hh := 75.0-1, 15.0-1
+	sin, cos := math.Sincos(a * math.Pi / 180)
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			dx, dy := float64(x-cx)+0.5, float64(y-cy)+0.5
+			u, w := dx*cos+dy*sin, -dx*sin+dy*cos
+			if math.Abs(u) < hw && math.Abs(w) < hh && dst.RGBAAt(x, y) == red {
+				t.Fatalf("pixel (%d, %d) of rotated bar left unpainted", x, y)
+			}
+		}
+	}
+}
+
+// TestSampleFill checks that fill=sample paints the bar the color of
+// the sampled point, so it vanishes into a uniform background.
+func TestSampleFill(t *testing.T) {
+	beige := color.RGBA{0xf5, 0xf5, 0xdc, 0xff}
+	m := solidImage(200, 100, beige)
+	// Something to hide.
+	for x := 80; x < 120; x++ {
+		m.SetRGBA(x, 50, color.RGBA{0, 0, 0, 0xff})
+	}
+	v := url.Values{"x": {"100"}, "y": {"50"}, "s": {"0"}, "fill": {"sample"}, "sample": {"5,5"}}
+	dst, err := render(formRequest(v), &Image{}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if got := dst.RGBAAt(x, y); got != beige {
+				t.Fatalf("pixel (%d, %d) is %v, want the background, %v", x, y, got, beige)
+			}
+		}
+	}
+	for _, p := range []string{"200,5", "5,-1", "5", "a,b"} {
+		v.Set("sample", p)
+		if _, err := render(formRequest(v), &Image{}, solidImage(200, 100, beige)); err == nil {
+			t.Errorf("sample=%s accepted, want an error", p)
+		}
+	}
+}
+
+// TestNoiseSeed checks that noise fills are the same for the same seed,
+// with or without one given, and differ between seeds.
+func TestNoiseSeed(t *testing.T) {
+	noise := func(seed string) []byte {
+		v := url.Values{"x": {"100"}, "y": {"50"}, "s": {"1"}, "fill": {"noise"}}
+		if seed != "" {
+			v.Set("seed", seed)
+		}
+		dst, err := render(formRequest(v), &Image{}, solidImage(200, 100, color.White))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dst.Pix
+	}
+	if string(noise("")) != string(noise("")) {
+		t.Error("default noise differs between renders")
+	}
+	if string(noise("42")) != string(noise("42")) {
+		t.Error("noise with seed 42 differs between renders")
+	}
+	if string(noise("42")) == string(noise("43")) {
+		t.Error("seeds 42 and 43 give the same noise")
+	}
+	if string(noise("")) != string(noise("1")) {
+		t.Error("default noise is not seeded with 1")
+	}
+}
+
+// TestTextFillCap checks that fill=text refuses text longer than
+// maxTextLen, rather than drawing a tile as wide as the request.
+func TestTextFillCap(t *testing.T) {
+	v := url.Values{"x": {"100"}, "y": {"50"}, "fill": {"text"}}
+	for _, tt := range []struct {
+		n  int
+		ok bool
+	}{
+		{maxTextLen, true},
+		{maxTextLen + 1, false},
+		{10 << 20, false},
+	} {
+		v.Set("text", strings.Repeat("x", tt.n))
+		_, err := render(formRequest(v), &Image{}, solidImage(200, 100, color.White))
+		if (err == nil) != tt.ok {
+			t.Errorf("text of %d bytes: error %v, want error %v", tt.n, err, !tt.ok)
+		}
+	}
+	if got, want := textTile(strings.Repeat("x", 10<<20), textHeight, color.White, color.Black).Bounds().Dx(), maxTextLen*textAdvance; got != want {
+		t.Errorf("textTile of long text is %d wide, want %d", got, want)
+	}
+}
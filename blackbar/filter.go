@@ -0,0 +1,109 @@
+package blackbar
+
+import "image"
+
+// luminance returns the luma of an 8-bit RGB color, as in
+// color.RGBToYCbCr.
+func luminance(r, g, b uint8) uint8 {
+	return uint8((19595*int32(r) + 38470*int32(g) + 7471*int32(b) + 1<<15) >> 16)
+}
+
+// histogram counts the pixels of m at each luminance level.
+func histogram(m *image.RGBA) (h [256]int) {
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		p := m.Pix[m.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			h[luminance(p[4*x], p[4*x+1], p[4*x+2])]++
+		}
+	}
+	return h
+}
+
+// autoContrast stretches the colors of m linearly so that the darkest
+// lowPct percent of its pixels become black and the brightest
+// 100-highPct percent become white. Percentiles rather than the plain
+// minimum and maximum keep a few stray pixels from spoiling the result.
+func autoContrast(m *image.RGBA, lowPct, highPct float64) {
+	h := histogram(m)
+	total := 0
+	for _, n := range h {
+		total += n
+	}
+	lo, hi := -1, -1
+	sum := 0
+	for v, n := range h {
+		sum += n
+		if lo < 0 && float64(sum) > lowPct/100*float64(total) {
+			lo = v
+		}
+		if hi < 0 && float64(sum) >= highPct/100*float64(total) {
+			hi = v
+		}
+	}
+	if lo < 0 || hi <= lo {
+		return // blank image, or nothing to stretch
+	}
+	var table [256]uint8
+	for v := range table {
+		s := (v - lo) * 255 / (hi - lo)
+		if s < 0 {
+			s = 0
+		} else if s > 255 {
+			s = 255
+		}
+		table[v] = uint8(s)
+	}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		p := m.Pix[m.PixOffset(b.Min.X, y):]
+		for i := 0; i < 4*b.Dx(); i += 4 {
+			p[i+0] = table[p[i+0]]
+			p[i+1] = table[p[i+1]]
+			p[i+2] = table[p[i+2]]
+		}
+	}
+}
+
+// tone recolors every pixel of m with the filter named: gray replaces
+// each color with its luminance, and sepia with the brownish tint of old
+// photographs. It reports false if the name is unknown.
+func tone(m *image.RGBA, name string) bool {
+	var f func(r, g, b int) (int, int, int)
+	switch name {
+	case "gray":
+		f = func(r, g, b int) (int, int, int) {
+			y := int(luminance(uint8(r), uint8(g), uint8(b)))
+			return y, y, y
+		}
+	case "sepia":
+		// The usual weights, in thousandths.
+		f = func(r, g, b int) (int, int, int) {
+			return (393*r + 769*g + 189*b) / 1000,
+				(349*r + 686*g + 168*b) / 1000,
+				(272*r + 534*g + 131*b) / 1000
+		}
+	default:
+		return false
+	}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		p := m.Pix[m.PixOffset(b.Min.X, y):]
+		for i := 0; i < 4*b.Dx(); i += 4 {
+			r, g, bl := f(int(p[i+0]), int(p[i+1]), int(p[i+2]))
+			// Colors are premultiplied, so none may exceed alpha.
+			a := int(p[i+3])
+			if r > a {
+				r = a
+			}
+			if g > a {
+				g = a
+			}
+			if bl > a {
+				bl = a
+			}
+			p[i+0], p[i+1], p[i+2] = uint8(r), uint8(g), uint8(bl)
+		}
+	}
+	return true
+}
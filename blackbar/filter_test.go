@@ -0,0 +1,61 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestAutoContrast checks that a low-contrast ramp is stretched to
+// cover the full range, keeping its order.
+func TestAutoContrast(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 100, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 100; x++ {
+			v := uint8(100 + x*50/99) // 100 to 150
+			m.SetRGBA(x, y, color.RGBA{v, v, v, 0xff})
+		}
+	}
+	before := histogram(m)
+	autoContrast(m, 1, 99)
+	after := histogram(m)
+	if lo, hi := levels(before); lo != 100 || hi != 150 {
+		t.Fatalf("test image ranges from %d to %d, want 100 to 150", lo, hi)
+	}
+	if lo, hi := levels(after); lo != 0 || hi != 255 {
+		t.Errorf("stretched image ranges from %d to %d, want 0 to 255", lo, hi)
+	}
+	for x := 1; x < 100; x++ {
+		if m.RGBAAt(x, 0).R < m.RGBAAt(x-1, 0).R {
+			t.Fatalf("pixel %d darker than pixel %d after stretching", x, x-1)
+		}
+	}
+	if a := m.RGBAAt(50, 5).A; a != 0xff {
+		t.Errorf("alpha changed to %#x", a)
+	}
+}
+
+// TestAutoContrastBlank checks that an image of one color is left as
+// it is.
+func TestAutoContrastBlank(t *testing.T) {
+	gray := color.RGBA{0x80, 0x80, 0x80, 0xff}
+	m := solidImage(10, 10, gray)
+	autoContrast(m, 1, 99)
+	if got := m.RGBAAt(5, 5); got != gray {
+		t.Errorf("blank image changed to %v", got)
+	}
+}
+
+// levels returns the lowest and highest levels used in histogram h.
+func levels(h [256]int) (lo, hi int) {
+	lo, hi = -1, -1
+	for v, n := range h {
+		if n > 0 {
+			if lo < 0 {
+				lo = v
+			}
+			hi = v
+		}
+	}
+	return lo, hi
+}
@@ -0,0 +1,86 @@
+package blackbar
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// Page sizes for gallery.
+const (
+	galleryLimit    = 20
+	galleryMaxLimit = 100
+)
+
+// galleryEntry is how gallery lists an image.
+type galleryEntry struct {
+	ID       string    `json:"id"`
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	URL      string    `json:"url"`
+	Uploaded time.Time `json:"uploaded"`
+}
+
+// newGalleryEntry returns the entry listing image id, stored as im. Its
+// URL is of a thumbnail, which suits a gallery better than the image
+// itself.
+func newGalleryEntry(id string, im *Image) galleryEntry {
+	return galleryEntry{id, im.Width, im.Height, "/thumb?id=" + id, im.Uploaded}
+}
+
+// gallery is the HTTP handler for the gallery manifest; it handles
+// "/gallery.json". It lists the most recently uploaded images, newest
+// first, with what a front end needs to lay them out, limit (default 20)
+// at a time. The cursor in the response fetches the next page; it is
+// empty on the last one.
+//
+// The query is a projection, so it never reads the image data. Images
+// stored before uploads were timestamped are not listed.
+func gallery(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		limit = galleryLimit
+	}
+	if limit > galleryMaxLimit {
+		limit = galleryMaxLimit
+	}
+	q := datastore.NewQuery("Image").
+		Project("Width", "Height", "Uploaded").
+		Order("-Uploaded").
+		Limit(limit)
+	if s := r.FormValue("cursor"); s != "" {
+		cursor, err := datastore.DecodeCursor(s)
+		if err != nil {
+			http.Error(w, "bad cursor", http.StatusBadRequest)
+			return
+		}
+		q = q.Start(cursor)
+	}
+
+	res := struct {
+		Images []galleryEntry `json:"images"`
+		Cursor string         `json:"cursor"`
+	}{Images: []galleryEntry{}}
+	t := q.Run(c)
+	for {
+		var im Image
+		key, err := t.Next(&im)
+		if err == datastore.Done {
+			break
+		}
+		check(err)
+		res.Images = append(res.Images, newGalleryEntry(key.StringID(), &im))
+	}
+	if len(res.Images) == limit {
+		cursor, err := t.Cursor()
+		check(err)
+		res.Cursor = cursor.String()
+	}
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
+}
@@ -0,0 +1,22 @@
+package blackbar
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestGalleryEntry checks the fields of an image in the manifest, and
+// that its URL serves a thumbnail.
+func TestGalleryEntry(t *testing.T) {
+	up := time.Date(2012, 6, 1, 12, 0, 0, 0, time.UTC)
+	e := newGalleryEntry("abc", &Image{Width: 640, Height: 480, Uploaded: up})
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"abc","width":640,"height":480,"url":"/thumb?id=abc","uploaded":"2012-06-01T12:00:00Z"}`
+	if string(b) != want {
+		t.Errorf("entry = %s, want %s", b, want)
+	}
+}
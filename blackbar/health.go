@@ -0,0 +1,33 @@
+package blackbar
+
+import (
+	"io"
+	"net/http"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// Health checks for load balancers and monitoring. Neither handler is
+// wrapped in errorHandler, so the status is always the one written here.
+
+// healthz is the HTTP handler reporting that the service is up; it
+// handles "/healthz". It does no work beyond answering.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "text/plain")
+	io.WriteString(w, "ok\n")
+}
+
+// readyz is the HTTP handler reporting whether the service can reach
+// the datastore; it handles "/readyz". It reads at most one key, and
+// answers 503 Service Unavailable if that fails.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	if _, err := datastore.NewQuery("Image").KeysOnly().Limit(1).GetAll(c, nil); err != nil {
+		c.Errorf("readyz: %v", err)
+		http.Error(w, "datastore unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-type", "text/plain")
+	io.WriteString(w, "ok\n")
+}
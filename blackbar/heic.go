@@ -0,0 +1,15 @@
+//go:build heic
+// +build heic
+
+package blackbar
+
+// HEIC decoding needs cgo (libde265), so it is only compiled in when
+// building with -tags heic.
+
+import (
+	_ "github.com/jdeng/goheif" // import so we can read HEIC files.
+)
+
+func init() {
+	heicSupported = true
+}
@@ -0,0 +1,30 @@
+//go:build heic
+// +build heic
+
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"testing"
+)
+
+// TestDecodeHEIC checks that, with the decoder compiled in, a HEIC file
+// decodes like any other upload. Put a small one in testdata to run it.
+func TestDecodeHEIC(t *testing.T) {
+	data, err := ioutil.ReadFile("blackbar/testdata/small.heic")
+	if err != nil {
+		t.Skip("no HEIC fixture: ", err)
+	}
+	if !isHEIC(data) {
+		t.Fatal("fixture not recognized as HEIC")
+	}
+	m, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "heic" || m.Bounds().Empty() {
+		t.Errorf("decoded %s image of %v, want a heic image", format, m.Bounds())
+	}
+}
@@ -2,189 +2,827 @@ package blackbar
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
 	"image/jpeg"
-	_ "image/png" // import so we can read PNG files.
+	"image/png"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"text/template"
+	"time"
 )
 
 // These imports were added for deployment on App Engine.
 import (
 	"appengine"
 	"appengine/datastore"
+	"censor"
 	"crypto/sha1"
 	"resize"
 )
 
+// templateFiles are the page templates, relative to the application's
+// directory.
+var templateFiles = []string{
+	"edit.html",
+	"error.html",
+	"list.html",
+	"upload.html",
+}
+
 var (
-	templates = template.Must(template.ParseFiles(
-		"edit.html",
-		"error.html",
-		"upload.html",
-	))
+	templates     *template.Template
+	templatesOnce sync.Once
 )
 
+// templateSet returns the page templates: those parsed on first use, or,
+// if ReloadTemplates is set, those on disk now. It panics if they do
+// not parse. Parsing waits for first use so that the package can be
+// loaded, as by its tests, away from the application's directory.
+func templateSet() *template.Template {
+	if ReloadTemplates {
+		return template.Must(template.ParseFiles(templateFiles...))
+	}
+	templatesOnce.Do(func() {
+		templates = template.Must(template.ParseFiles(templateFiles...))
+	})
+	return templates
+}
+
 // Because App Engine owns main and starts the HTTP service,
 // we do our setup during initialization.
 func init() {
-	http.HandleFunc("/", errorHandler(upload))
-	http.HandleFunc("/edit", errorHandler(edit))
-	http.HandleFunc("/img", errorHandler(img))
+	http.HandleFunc("/", logged(cors(errorHandler(rateLimited(upload)))))
+	http.HandleFunc("/edit", logged(errorHandler(signed(edit))))
+	http.HandleFunc("/img", logged(cors(errorHandler(img))))
+	http.HandleFunc("/img/", logged(cors(errorHandler(imgPath))))
+	http.HandleFunc("/validate", logged(errorHandler(validate)))
+	http.HandleFunc("/package", logged(errorHandler(signed(bundle))))
+	http.HandleFunc("/reveal", logged(errorHandler(signed(reveal))))
+	http.HandleFunc("/stats", logged(errorHandler(signed(stats))))
+	http.HandleFunc("/gallery.json", logged(errorHandler(unlisted(gallery))))
+	http.HandleFunc("/admin/rekey", logged(errorHandler(rekey)))
+	http.HandleFunc("/admin/applyall", logged(errorHandler(applyAll)))
+	http.HandleFunc("/meta", logged(errorHandler(signed(meta))))
+	http.HandleFunc("/info", logged(errorHandler(signed(meta))))
+	http.HandleFunc("/lqip", logged(errorHandler(signed(lqip))))
+	http.HandleFunc("/thumb", logged(errorHandler(signed(thumb))))
+	http.HandleFunc("/preview", logged(errorHandler(signed(preview))))
+	http.HandleFunc("/sprite", logged(errorHandler(signed(sprite))))
+	http.HandleFunc("/list", logged(errorHandler(unlisted(list))))
+	http.HandleFunc("/delete", logged(errorHandler(signed(remove))))
+	http.HandleFunc("/undo", logged(errorHandler(signed(undo))))
+	http.HandleFunc("/crop", logged(errorHandler(signed(cropStored))))
+	http.HandleFunc("/flip", logged(errorHandler(signed(flip))))
+	http.HandleFunc("/rotate", logged(errorHandler(signed(rotate))))
+	http.HandleFunc("/ws", logged(errorHandler(liveEdit)))
+	http.HandleFunc("/raw", logged(errorHandler(signed(raw))))
+	http.HandleFunc("/export", logged(errorHandler(signed(export))))
+	http.HandleFunc("/batch", logged(errorHandler(rateLimited(batch))))
+	http.HandleFunc("/overlay", logged(errorHandler(rateLimited(uploadOverlay))))
+	http.HandleFunc("/healthz", healthz)
+	http.HandleFunc("/readyz", readyz)
+	http.HandleFunc("/metrics", serveMetrics)
+	http.HandleFunc("/api/upload", logged(cors(apiHandler(rateLimited(apiUpload)))))
+	http.HandleFunc("/api/bar", logged(cors(apiHandler(signed(apiBar)))))
 }
 
 // Image is the type used to hold the image in the datastore.
 type Image struct {
 	Data []byte
+	Exif []byte // EXIF payload of the upload, if RetainEXIF is set
+
+	// Set at upload, so listings need not decode Data.
+	Width, Height int
+	Uploaded      time.Time
+
+	// Orientation is the EXIF orientation upload applied to make the
+	// image upright, or 0 if none.
+	Orientation int
+
+	// Original is the image as uploaded, if any edits have been
+	// saved, and Edits their form values, url-encoded, in the order
+	// they were saved. Data is Original with the edits applied (see
+	// undo.go).
+	Original []byte
+	Edits    []string
+
+	// ContentType is the type of Data, image/jpeg or image/png. It is
+	// empty for images stored before it was recorded, which are all
+	// JPEG; use contentType to read it.
+	ContentType string
+}
+
+// contentType returns the type of im's data.
+func (im *Image) contentType() string {
+	if im.ContentType == "" {
+		return "image/jpeg"
+	}
+	return im.ContentType
+}
+
+// encodeStored writes m to w as it is stored with the content type
+// ctype: PNG, or otherwise JPEG at the given quality (0 for the
+// default).
+func encodeStored(w io.Writer, m image.Image, ctype string, quality int) error {
+	if ctype == "image/png" {
+		return png.Encode(w, m)
+	}
+	return jpeg.Encode(w, m, output{Quality: quality}.jpegOptions())
 }
 
 // upload is the HTTP handler for uploading images; it handles "/".
 func upload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		// No upload; show the upload form.
-		templates.ExecuteTemplate(w, "upload.html", nil)
+		d := newPageData()
+		d.CSRF = csrfToken(w, r)
+		templateSet().ExecuteTemplate(w, "upload.html", d)
+		return
+	}
+	if !parseUpload(w, r, "Images") {
+		return
+	}
+	if !checkCSRF(w, r) {
 		return
 	}
 
-	f, _, err := r.FormFile("image")
-	check(err)
-	defer f.Close()
+	// Create an App Engine context for the client's request.
+	c := appengine.NewContext(r)
 
-	// Grab the image data
+	// Grab the image data, from the file uploaded or else the URL given.
 	var buf bytes.Buffer
-	io.Copy(&buf, f)
-	i, _, err := image.Decode(&buf)
+	f, _, err := r.FormFile("image")
+	if u := r.FormValue("url"); err == http.ErrMissingFile && u != "" {
+		data, err := fetchURL(c, u)
+		check(err)
+		buf.Write(data)
+	} else {
+		check(badRequest(err))
+		defer f.Close()
+		io.Copy(&buf, f)
+	}
+	how, err := scalingOf(r)
 	check(err)
+	key := store(c, buf.Bytes(), outputOf(r).Quality, how)
+
+	// Redirect to /edit using the key.
+	http.Redirect(w, r, editURL(key.StringID()), http.StatusFound)
+}
+
+// parseUpload parses the multipart form of r, an upload of the things
+// named by what, such as "Images", reporting whether it could. Uploads
+// larger than MaxUploadSize are refused with 413 Request Entity Too
+// Large: up front if they say how large they are, before they are read
+// into memory, and otherwise once reading them passes the limit.
+func parseUpload(w http.ResponseWriter, r *http.Request, what string) bool {
+	tooLarge := fmt.Sprintf("%s may be at most %d MB.", what, MaxUploadSize>>20)
+	if r.ContentLength > MaxUploadSize {
+		errorPage(w, http.StatusRequestEntityTooLarge, tooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
+	if err := r.ParseMultipartForm(MaxUploadSize); err != nil {
+		if bodyTooLarge(err) {
+			errorPage(w, http.StatusRequestEntityTooLarge, tooLarge)
+		} else {
+			errorPage(w, http.StatusBadRequest, "The upload failed: "+err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// bodyTooLarge reports whether err comes from reading a request body
+// past the limit set by http.MaxBytesReader.
+func bodyTooLarge(err error) bool {
+	_, ok := err.(*http.MaxBytesError)
+	return ok
+}
+
+// scalingOf returns the scaling named by the scaling form value of r:
+// resample for line art and screenshots, smooth for photos, or empty
+// to choose by size.
+func scalingOf(r *http.Request) (censor.Scaling, error) {
+	switch how := censor.Scaling(r.FormValue("scaling")); how {
+	case censor.Adaptive, censor.Sampled, censor.Smoothed:
+		return how, nil
+	}
+	return "", badRequest(fmt.Errorf("unknown scaling %q", r.FormValue("scaling")))
+}
+
+// store decodes the uploaded image data, prepares it for editing and
+// stores it, returning its key. Large images are shrunk using the given
+// scaling, and JPEG output is encoded with the given quality, or 0 for
+// the default. It panics, through check, on failure.
+func store(c appengine.Context, data []byte, quality int, how censor.Scaling) *datastore.Key {
+	if isHEIC(data) && !heicSupported {
+		check(badRequest(errors.New("HEIC images are not supported by this server; please upload a JPEG or PNG")))
+	}
+	var exif []byte
+	if RetainEXIF {
+		exif = append(exif, exifSegment(data)...)
+	}
+	orientation := 0
+	if x, err := parseExif(exifSegment(data)); err == nil && x.Orientation > 1 && x.Orientation <= 8 {
+		orientation = x.Orientation
+	}
+	i, format, err := image.Decode(bytes.NewReader(data))
+	countUpload(err == nil)
+	check(badRequest(err))
+	if format == "gif" {
+		g, err := decodeAnimation(data)
+		check(err)
+		if g != nil {
+			return storeAnimation(c, g)
+		}
+	}
+	i = prepare(i, orientation, how)
+
+	// Encode as a new image: PNG stays PNG, losslessly, and everything
+	// else becomes JPEG.
+	ctype := "image/jpeg"
+	if format == "png" {
+		ctype = "image/png"
+	}
+	return put(c, &Image{Exif: exif, Orientation: orientation, ContentType: ctype}, i, quality)
+}
+
+// prepare returns m, an upload with EXIF orientation o, ready to be
+// stored for editing, scaling it down if need be with the given scaling.
+func prepare(m image.Image, o int, how censor.Scaling) image.Image {
+	// Turn phone photos upright, so stored pixels are the way the
+	// photo is meant to be seen, and bars land where they are put.
+	m = upright(m, o)
 
 	// Resize if too large, for more efficient blackbarring.
-	// We aim for less than 1200 pixels in any dimension; if the
-	// picture is larger than that, we squeeze it down to 600.
-	const max = 1200
-	if b := i.Bounds(); b.Dx() > max || b.Dy() > max {
-		// If it's gigantic, it's more efficient to downsample first
-		// and then resize; resizing will smooth out the roughness.
-		if b.Dx() > 2*max || b.Dy() > 2*max {
-			w, h := max, max
-			if b.Dx() > b.Dy() {
-				h = b.Dy() * h / b.Dx()
-			} else {
-				w = b.Dx() * w / b.Dy()
+	m = censor.Shrink(m, MaxDimension, how)
+
+	return censor.Paint(m, DefaultBars, image.NewUniform(color.Black), censor.Solid)
+}
+
+// put encodes m as im's content type says, at the given JPEG quality,
+// and stores it as im's data, returning its key. It panics, through
+// check, on failure.
+func put(c appengine.Context, im *Image, m image.Image, quality int) *datastore.Key {
+	var buf bytes.Buffer
+	check(encodeStored(&buf, m, im.contentType(), quality))
+	b := m.Bounds()
+	im.Data, im.Width, im.Height = buf.Bytes(), b.Dx(), b.Dy()
+	return insert(c, im)
+}
+
+// insert stores im, whose data is set, returning its key. It panics,
+// through check, on failure.
+func insert(c appengine.Context, im *Image) *datastore.Key {
+	im.Uploaded = time.Now()
+
+	// Save the image under a unique key, a hash of the image. The same
+	// image stored again gets the same key; it is stored only once, and
+	// whoever stores it again reuses what is there. What is there may
+	// since have been edited, though, so an image found changed is not
+	// reused: the upload goes under the next key of its copies instead,
+	// the hash followed by -1, -2 and so on, each reused in turn while
+	// it is as uploaded.
+	id := keyOf(im.Data)
+	for i := 0; ; i++ {
+		key := datastore.NewKey(c, "Image", copyID(id, i), 0, nil)
+		reused, stored := false, false
+		err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+			old := new(Image)
+			err := datastore.Get(c, key, old)
+			if err == nil {
+				reused = reusable(old, im)
+				return nil
 			}
-			i = resize.Resample(i, i.Bounds(), w, h)
-			b = i.Bounds()
+			if err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			_, err = datastore.Put(c, key, im)
+			stored = err == nil
+			return err
+		}, nil)
+		check(err)
+		if stored {
+			countStored(len(im.Data))
 		}
-		w, h := max/2, max/2
-		if b.Dx() > b.Dy() {
-			h = b.Dy() * h / b.Dx()
-		} else {
-			w = b.Dx() * w / b.Dy()
+		if stored || reused {
+			return key
 		}
-		i = resize.Resize(i, i.Bounds(), w, h)
 	}
+}
 
-	// Encode as a new JPEG image.
-	buf.Reset()
-	err = jpeg.Encode(&buf, i, nil)
-	check(err)
+// copyID returns the id of copy i of the image whose hash is id: the
+// hash itself for the first, and the hash followed by "-i" for others.
+func copyID(id string, i int) string {
+	if i == 0 {
+		return id
+	}
+	return fmt.Sprintf("%s-%d", id, i)
+}
 
-	// Create an App Engine context for the client's request.
-	c := appengine.NewContext(r)
+// reusable reports whether old, an image stored under one of the keys
+// of im's copies, can stand for im: it is as uploaded, with no edits
+// saved on it, and holds the same data.
+func reusable(old, im *Image) bool {
+	return len(old.Edits) == 0 && bytes.Equal(old.Data, im.Data)
+}
 
-	// Save the image under a unique key, a hash of the image.
-	key := datastore.NewKey(c, "Image", keyOf(buf.Bytes()), 0, nil)
-	_, err = datastore.Put(c, key, &Image{buf.Bytes()})
-	check(err)
+// heicSupported reports whether a HEIC decoder was compiled in
+// (see heic.go).
+var heicSupported bool
 
-	// Redirect to /edit using the key.
-	http.Redirect(w, r, "/edit?id="+key.StringID(), http.StatusFound)
+// isHEIC reports whether data looks like a HEIC/HEIF file, judging by
+// the brand of its leading ftyp box.
+func isHEIC(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	switch string(data[8:12]) {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return true
+	}
+	return false
 }
 
-// keyOf returns (part of) the SHA-1 hash of the data, as a hex string.
+// keyOf returns the SHA-1 hash of the data, as a hex string. Images
+// stored under the truncated hashes it once returned are moved by rekey.
 func keyOf(data []byte) string {
 	sha := sha1.New()
 	sha.Write(data)
-	return fmt.Sprintf("%x", string(sha.Sum(nil))[0:8])
+	return fmt.Sprintf("%x", sha.Sum(nil))
+}
+
+// PageData is what every page template is executed with. Pages use the
+// fields that concern them; the rest are left zero.
+type PageData struct {
+	ID            string      // of the image the page is about
+	Width, Height int         // of that image
+	Error         string      // message for the error page
+	CSRF          string      // token for forms that make changes (see csrf.go)
+	Bar           *censor.Bar // where the editor first places the bar, if given
+	Query         string      // naming that image, signed if need be (see sign.go)
+
+	Images []ListEntry // for the list page
+	Cursor string      // of its next page, if any
+
+	// Optional features compiled into this build.
+	HEIC, AVIF, WebP bool
+}
+
+// newPageData returns a PageData with the feature flags filled in.
+func newPageData() *PageData {
+	return &PageData{HEIC: heicSupported, AVIF: encodeAVIF != nil, WebP: encodeWebP != nil}
 }
 
-// edit is the HTTP handler for editing images; it handles "/edit".
+// edit is the HTTP handler for editing images; it handles "/edit". If
+// the x, y and s form values are given, the editor starts with the bar
+// there; the editor keeps them in its URL as the bar moves, so a session
+// survives a reload.
 func edit(w http.ResponseWriter, r *http.Request) {
-	templates.ExecuteTemplate(w, "edit.html", r.FormValue("id"))
+	c := appengine.NewContext(r)
+	im, key, err := fetch(c, r.FormValue("id"))
+	check(err)
+	d := newPageData()
+	d.ID, d.Width, d.Height = key.StringID(), im.Width, im.Height
+	d.CSRF = csrfToken(w, r)
+	d.Query = signedID(d.ID)
+	if r.FormValue("x") != "" && r.FormValue("y") != "" {
+		b, err := barOf(r)
+		check(badRequest(err))
+		d.Bar = &b
+	}
+	templateSet().ExecuteTemplate(w, "edit.html", d)
 }
 
 // img is the HTTP handler for displaying images and painting blackbars;
 // it handles "/img".
 func img(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if !checkSignature(w, r) {
+		return
+	}
+	if r.FormValue("n") != "" && r.Method != "POST" {
+		// Saving changes the stored image, so it must not happen on
+		// a GET that a prefetcher or crawler might make.
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "saving a blackbar requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.FormValue("n") != "" && !checkCSRF(w, r) {
+		return
+	}
+	if r.FormValue("n") != "" && r.FormValue("trace") != "" {
+		http.Error(w, "a traced copy cannot be saved", http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("n") != "" && r.FormValue("overlay") != "" {
+		http.Error(w, "an overlay cannot be saved", http.StatusBadRequest)
+		return
+	}
 	c := appengine.NewContext(r)
-	key := datastore.NewKey(c, "Image", r.FormValue("id"), 0, nil)
+	im, key, err := fetchCached(c, r.FormValue("id"))
+	if err == datastore.ErrNoSuchEntity && ServePlaceholder {
+		servePlaceholder(w)
+		return
+	}
+	check(err)
+	if r.FormValue("fmt") == "" && im.contentType() != "image/gif" {
+		// Without a format named, the Accept header chooses, and
+		// what is served depends on it.
+		w.Header().Add("Vary", "Accept")
+		if f := negotiateFormat(r, im.contentType()); f != "" {
+			r.Form.Set("fmt", f)
+		}
+	}
+	etag := renditionTag(im, r)
+	if r.FormValue("n") == "" {
+		// The id can be saved over, so caches must check back
+		// before long.
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", ImageMaxAge/time.Second))
+		if notModified(w, r, etag) {
+			return
+		}
+	}
+	if im.contentType() == "image/gif" {
+		serveAnimation(c, w, r, im, etag)
+		return
+	}
+	if untouched(r, im.contentType()) {
+		// Nothing to do; serve the stored image as is rather than
+		// lose quality to another encoding.
+		writeRendition(c, w, r, im.contentType(), im.Data, etag)
+		return
+	}
+	m, err := decodeCached(c, key.StringID(), im)
+	check(err)
+	noteSize(w, m.Bounds().Size())
+	overlay, err := loadOverlay(c, r.FormValue("overlay"))
+	check(err)
+	dst, err := renderOver(r, im, m, overlay)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("exifcaption") != "" && im.Exif != nil {
+		exifCaption(dst, im.Exif)
+	}
+	if r.FormValue("trace") != "" { // mark the copy with its id
+		watermark(dst, key.StringID(), TraceOpacity)
+	}
+	if formats := strings.Split(r.FormValue("fmt"), ","); len(formats) > 1 {
+		if r.FormValue("n") != "" {
+			http.Error(w, "saving takes a single format", http.StatusBadRequest)
+			return
+		}
+		serveRenditions(w, r, dst, formats)
+		censor.Release(dst)
+		return
+	}
+	o := outputOf(r)
+	if o.Format == "" && im.contentType() == "image/png" {
+		o.Format = "png" // serve as stored
+	}
+	icc := r.FormValue("icc")
+	if icc != "" && icc != "srgb" {
+		http.Error(w, "unknown color profile "+icc, http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("n") == "" && icc == "" && !ServeDeltas {
+		// Nothing else needs the encoded image, so it goes straight
+		// to the client rather than through a buffer.
+		w.Header().Set("Content-type", o.contentType())
+		w.Header().Set("ETag", etag)
+		_, err = o.encode(w, dst)
+		check(err)
+		countRender(r.FormValue("mode"), time.Since(start))
+		censor.Release(dst)
+		return
+	}
+
+	var out []byte
+	ctype := o.contentType()
+	if r.FormValue("n") != "" { // save the current blackbar to store
+		// The edit goes onto the image as stored, not the cached
+		// copy rendered above, which another save may have passed.
+		saved, err := saveEdit(c, key.StringID(), editOf(r), o.Quality)
+		check(err)
+		forget(c, key.StringID())
+		etag = "" // the data it was computed from is gone
+		if ctype == saved.contentType() {
+			out = saved.Data // what was just stored; no need to encode again
+		}
+	}
+	if out == nil {
+		var buf bytes.Buffer
+		ctype, err = o.encode(&buf, dst)
+		check(err)
+		out = buf.Bytes()
+	}
+	countRender(r.FormValue("mode"), time.Since(start))
+	if icc == "srgb" && ctype == "image/jpeg" {
+		out, err = embedICC(out, srgb())
+		check(err)
+	}
+	writeRendition(c, w, r, ctype, out, etag)
+	censor.Release(dst)
+}
+
+// untouched reports whether r asks img for the stored image as it is:
+// it names an image, and at most the format it is stored in, given by
+// its content type ctype. Values that sign the URL, or otherwise do not
+// change the image, do not count.
+func untouched(r *http.Request, ctype string) bool {
+	r.ParseForm()
+	for k, v := range r.Form {
+		switch {
+		case k == "id", k == "scope", isUnsigned(k):
+		case k == "fmt" && len(v) == 1 && (v[0] == "" || "image/"+v[0] == ctype):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// imgPath is the HTTP handler for displaying images by path, as in
+// "/img/<id>.jpg"; it handles "/img/". The id and the format named by the
+// extension stand in for the id and fmt form values of img.
+func imgPath(w http.ResponseWriter, r *http.Request) {
+	if !pathForm(r) {
+		http.NotFound(w, r)
+		return
+	}
+	img(w, r)
+}
+
+// pathForm sets the id and fmt form values of r, a request for
+// "/img/<id>.<ext>", from its path. It reports false if the path does
+// not name an image so.
+func pathForm(r *http.Request) bool {
+	id, ext := strings.TrimPrefix(r.URL.Path, "/img/"), ""
+	if i := strings.LastIndex(id, "."); i >= 0 {
+		id, ext = id[:i], id[i+1:]
+	}
+	format, ok := extFormats[ext]
+	if id == "" || strings.ContainsAny(id, "/.") || !ok && ext != "" {
+		return false
+	}
+	r.ParseForm()
+	r.Form.Set("id", id)
+	if format != "" {
+		r.Form.Set("fmt", format)
+	}
+	return true
+}
+
+// render applies the edits requested by the form values of r to m, the
+// decoded form of im. The error, if any, describes a malformed request.
+func render(r *http.Request, im *Image, m image.Image) (*image.RGBA, error) {
+	return renderOver(r, im, m, nil)
+}
+
+// renderOver is render, placing overlay, the image the overlay form
+// value names, at each bar instead of painting the bar. Only img loads
+// overlays; elsewhere a request for one is an error.
+func renderOver(r *http.Request, im *Image, m, overlay image.Image) (*image.RGBA, error) {
+	if err := checkOperations(r); err != nil {
+		return nil, err
+	}
+	dst := censor.RGBA(m)
+	if r.FormValue("autocontrast") != "" {
+		autoContrast(dst, 0.5, 99.5)
+	}
+	fill, err := fillOf(r, dst)
+	if err != nil {
+		return nil, err
+	}
+	anchor, err := anchorOf(r)
+	if err != nil {
+		return nil, err
+	}
+	bars, err := barsOf(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := placeBars(r, im, bars, anchor, dst.Bounds()); err != nil {
+		return nil, err
+	}
+	mode := censor.Mode(r.FormValue("mode"))
+	switch mode {
+	case "", censor.Solid, censor.Blurred, censor.Pixelated, censor.Outlined:
+	default:
+		return nil, fmt.Errorf("unknown mode %q", mode)
+	}
+	if r.FormValue("overlay") != "" {
+		if overlay == nil {
+			return nil, errors.New("overlays are only shown by img, never saved")
+		}
+		for _, b := range bars {
+			if b.X > 0 {
+				drawOverlay(dst, overlay, b)
+			}
+		}
+	} else {
+		dst = censor.Paint(dst, bars, fill, mode)
+		// With fill=text the text is the fill itself; otherwise it
+		// labels each bar.
+		text, err := textOf(r)
+		if err != nil {
+			return nil, err
+		}
+		if text != "" && r.FormValue("fill") != "text" {
+			labelBars(dst, bars, text, fill)
+		}
+	}
+	boxes, err := boxesOf(r, dst.Bounds().Size())
+	if err != nil {
+		return nil, err
+	}
+	for _, br := range boxes {
+		censor.PaintRect(dst, br.Add(dst.Bounds().Min), fill, mode)
+	}
+	if r.FormValue("stroke") != "" {
+		pts, thickness, err := strokeOf(r, dst.Bounds().Sub(anchor))
+		if err != nil {
+			return nil, err
+		}
+		for i := range pts {
+			pts[i] = pts[i].Add(anchor)
+		}
+		drawStroke(dst, pts, thickness, color.Black)
+	}
+	if bg := r.FormValue("bg"); bg != "" {
+		var ok bool
+		if dst, ok = background(dst, bg); !ok {
+			return nil, fmt.Errorf("bad background %q: want checker or a hex color", bg)
+		}
+	}
+	// The filter recolors the whole picture, bars and background too,
+	// so it comes after them.
+	if f := r.FormValue("filter"); f != "" && !tone(dst, f) {
+		return nil, fmt.Errorf("unknown filter %q: want gray or sepia", f)
+	}
+	crop, ok, err := cropOf(r, dst.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		dst = cropped(dst, crop)
+	}
+	return dst, nil
+}
+
+// checkOperations returns an error if r carries more than MaxOperations
+// form values.
+func checkOperations(r *http.Request) error {
+	r.ParseForm()
+	n := 0
+	for _, v := range r.Form {
+		n += len(v)
+	}
+	if n > MaxOperations {
+		return fmt.Errorf("request has %d parameters, more than the limit of %d", n, MaxOperations)
+	}
+	return nil
+}
+
+// fetch returns the Image stored under id.
+func fetch(c appengine.Context, id string) (*Image, *datastore.Key, error) {
+	if id == "" {
+		return nil, nil, badRequest(errors.New("no image id given"))
+	}
+	key := datastore.NewKey(c, "Image", id, 0, nil)
 	im := new(Image)
 	err := datastore.Get(c, key, im)
-	check(err)
+	return im, key, err
+}
 
+// load fetches the image stored under id and decodes it.
+func load(c appengine.Context, id string) (image.Image, *datastore.Key, error) {
+	im, key, err := fetch(c, id)
+	if err != nil {
+		return nil, key, err
+	}
 	m, _, err := image.Decode(bytes.NewBuffer(im.Data))
-	check(err)
+	return m, key, err
+}
 
+// validate is the HTTP handler for checking bar placement; it handles
+// "/validate". It reports, as JSON, whether the bars cover the target
+// rectangle given by tx, ty, tw and th, and which parts they leave
+// uncovered.
+func validate(w http.ResponseWriter, r *http.Request) {
 	get := func(n string) int { // helper closure
 		i, _ := strconv.Atoi(r.FormValue(n))
 		return i
 	}
-	x, y, s := get("x"), get("y"), get("s")
-	dst := blackbar(m, x, y, s)
-	var buf bytes.Buffer
-	jpeg.Encode(&buf, dst, nil)
-	if r.FormValue("n") != "" { // save the current blackbar to store
-		_, err = datastore.Put(c, key, &Image{buf.Bytes()})
-		check(err)
+	target := image.Rect(0, 0, get("tw"), get("th")).Add(image.Pt(get("tx"), get("ty")))
+	if target.Empty() {
+		http.Error(w, "target rectangle must have a positive width and height", http.StatusBadRequest)
+		return
+	}
+	all, err := barsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var bars []image.Rectangle
+	for _, b := range all {
+		if b.X > 0 {
+			bars = append(bars, b.Rect())
+		}
 	}
-	w.Header().Set("Content-type", "image/jpeg")
-	io.Copy(w, &buf)
-}
 
-func blackbar(m image.Image, x, y, s int) image.Image {
-	dp := image.Pt(x, y)
-	sr := image.Rect(0, 0, (s+1)*50, (s+1)*10)
-	bbar := image.NewRGBA(sr)
-	draw.Draw(bbar, bbar.Bounds(), image.NewUniform(color.Black), image.ZP, draw.Src)
-	dst := rgba(m)
-	dst.Set(x, y, color.Black)
-	if x > 0 { // only draw if coordinates provided
-		r := image.Rectangle{dp.Sub(sr.Size().Div(2)), dp.Add(sr.Size().Div(2))}
-		draw.Draw(dst, r, bbar, image.ZP, draw.Src)
+	type rect struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+		W int `json:"w"`
+		H int `json:"h"`
+	}
+	res := struct {
+		Covered   bool   `json:"covered"`
+		Uncovered []rect `json:"uncovered"`
+	}{Uncovered: []rect{}}
+	for _, u := range uncovered(target, bars) {
+		res.Uncovered = append(res.Uncovered, rect{u.Min.X, u.Min.Y, u.Dx(), u.Dy()})
 	}
-	return dst
+	res.Covered = len(res.Uncovered) == 0
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
 }
 
-// rgba returns an RGBA version of the image, making a copy only if
-// necessary.
-func rgba(m image.Image) *image.RGBA {
-	if r, ok := m.(*image.RGBA); ok {
-		return r
-	}
+// thumbnail returns a copy of m scaled to width w, preserving its
+// aspect ratio.
+func thumbnail(m image.Image, w int) image.Image {
 	b := m.Bounds()
-	r := image.NewRGBA(b)
-	draw.Draw(r, b, m, image.ZP, draw.Src)
-	return r
+	// Only the width bounds it: no height w times that of m could be
+	// the tighter limit.
+	w, h := censor.FitWithin(b, w, w*b.Dy())
+	return resize.Resize(m, b, w, h)
 }
 
 // errorHandler wraps the argument handler with an error-catcher that
-// returns a 500 HTTP error if the request fails (calls check with err non-nil).
+// returns a 500 HTTP error if the request fails (calls check with err
+// non-nil), or panics for any other reason. Errors marked by badRequest
+// get a 400 instead.
 func errorHandler(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			if err, ok := recover().(error); ok {
-				w.WriteHeader(http.StatusInternalServerError)
-				templates.ExecuteTemplate(w, "error.html", err)
+			v := recover()
+			if v == nil {
+				return
 			}
+			err, ok := v.(error)
+			if !ok {
+				// A bug rather than a failed check, which has
+				// been logged already.
+				err = errors.New(fmt.Sprint(v))
+				log.Print("Panic: ", err)
+			}
+			status := http.StatusInternalServerError
+			if _, ok := err.(clientError); ok {
+				status = http.StatusBadRequest
+			}
+			noteError(w, err)
+			countFailure(status)
+			errorPage(w, status, err.Error())
 		}()
 		fn(w, r)
 	}
 }
 
+// clientError is an error caused by what the client sent, rather than
+// by a failure of the server.
+type clientError struct {
+	error
+}
+
+// badRequest marks err, if not nil, as the client's fault, so that
+// errorHandler answers it with 400 Bad Request.
+func badRequest(err error) error {
+	if err == nil {
+		return nil
+	}
+	return clientError{err}
+}
+
+// errorPage replies to the request with the error page, showing msg,
+// and the given status.
+func errorPage(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	d := newPageData()
+	d.Error = msg
+	templateSet().ExecuteTemplate(w, "error.html", d)
+}
+
 // check aborts the current execution if err is non-nil.
 func check(err error) {
 	if err != nil {
@@ -0,0 +1,242 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"censor"
+)
+
+// TestMain runs the tests from the application's directory, where the
+// page templates are.
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// multipartUpload returns a request uploading n bytes as the image file.
+// If chunked is set, the request does not say how long it is.
+func multipartUpload(n int, chunked bool) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("image", "big.jpg")
+	fw.Write(make([]byte, n))
+	mw.Close()
+	var src io.Reader = &body
+	if chunked {
+		src = io.MultiReader(&body) // hides the length
+	}
+	r := httptest.NewRequest("POST", "/", src)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if chunked {
+		r.ContentLength = -1
+	}
+	return r
+}
+
+// TestUploadTooLarge checks that uploads over MaxUploadSize get 413,
+// whether or not they say how large they are.
+func TestUploadTooLarge(t *testing.T) {
+	defer func(n int64) { MaxUploadSize = n }(MaxUploadSize)
+	MaxUploadSize = 1 << 10
+	for _, h := range []struct {
+		name string
+		fn   http.HandlerFunc
+	}{{"upload", upload}, {"batch", batch}, {"uploadOverlay", uploadOverlay}, {"apiUpload", apiUpload}} {
+		for _, chunked := range []bool{false, true} {
+			w := httptest.NewRecorder()
+			h.fn(w, multipartUpload(4<<10, chunked))
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("%s (chunked %v): got %d, want %d", h.name, chunked, w.Code, http.StatusRequestEntityTooLarge)
+			}
+		}
+	}
+}
+
+// TestUploadMalformed checks that a broken upload of allowed size is
+// a client error, not mistaken for one too large.
+func TestUploadMalformed(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", bytes.NewReader([]byte("not a form")))
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	w := httptest.NewRecorder()
+	upload(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// heicHeader returns the start of a HEIC file of the given brand.
+func heicHeader(brand string) []byte {
+	return append([]byte{0, 0, 0, 0x18}, "ftyp"+brand+"\x00\x00\x00\x00mif1heic"...)
+}
+
+func TestIsHEIC(t *testing.T) {
+	for _, tt := range []struct {
+		data []byte
+		want bool
+	}{
+		{heicHeader("heic"), true},
+		{heicHeader("mif1"), true},
+		{heicHeader("avif"), false},
+		{heicHeader("isom"), false},
+		{[]byte("ftypheic"), false},
+		{[]byte{0xff, 0xd8, 0xff, 0xe0, 0, 0x10, 'J', 'F', 'I', 'F', 0, 1}, false},
+		{nil, false},
+	} {
+		if got := isHEIC(tt.data); got != tt.want {
+			t.Errorf("isHEIC(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+// TestHEICUnsupported checks that, without a HEIC decoder, uploading a
+// HEIC file is refused with a message saying so, not a decoding error.
+func TestHEICUnsupported(t *testing.T) {
+	defer func(b bool) { heicSupported = b }(heicSupported)
+	heicSupported = false
+	defer func() {
+		err, ok := recover().(error)
+		if _, client := err.(clientError); !ok || !client {
+			t.Fatalf("store panicked with %v, want a client error", err)
+		}
+		if !strings.Contains(err.Error(), "HEIC") {
+			t.Errorf("error %q does not mention HEIC", err)
+		}
+	}()
+	store(nil, heicHeader("heic"), 0, censor.Adaptive)
+	t.Error("store accepted a HEIC file")
+}
+
+// TestMaxOperations checks that render refuses requests with more form
+// values than MaxOperations, and the API more bars.
+func TestMaxOperations(t *testing.T) {
+	defer func(n int) { MaxOperations = n }(MaxOperations)
+	MaxOperations = 6
+	v := url.Values{"x": {"10", "20", "30"}, "y": {"10", "20", "30"}}
+	if _, err := render(formRequest(v), &Image{}, image.NewRGBA(image.Rect(0, 0, 40, 40))); err != nil {
+		t.Errorf("render with %d values: %v", MaxOperations, err)
+	}
+	v.Add("x", "40")
+	if _, err := render(formRequest(v), &Image{}, image.NewRGBA(image.Rect(0, 0, 40, 40))); err == nil {
+		t.Errorf("render with %d values succeeded, want an error", MaxOperations+1)
+	}
+
+	body := `{"id": "x", "bars": [` + strings.Repeat(`{"x": 10, "y": 10},`, MaxOperations) + `{"x": 10, "y": 10}]}`
+	w := httptest.NewRecorder()
+	errorHandler(apiBar)(w, httptest.NewRequest("POST", "/api/bar", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("API with %d bars: got %d, want %d", MaxOperations+1, w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestPrepareDefaultBars checks that DefaultBars are painted onto every
+// upload, and nothing else is.
+func TestPrepareDefaultBars(t *testing.T) {
+	defer func(bars []censor.Bar) { DefaultBars = bars }(DefaultBars)
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	black := color.RGBA{0, 0, 0, 0xff}
+
+	DefaultBars = nil
+	m := censor.RGBA(prepare(solidImage(200, 100, white), 1, censor.Adaptive))
+	if m.RGBAAt(100, 50) != white {
+		t.Error("bar painted with no DefaultBars")
+	}
+
+	DefaultBars = []censor.Bar{{X: 100, Y: 50, S: 0}} // (75, 45) to (125, 55)
+	m = censor.RGBA(prepare(solidImage(200, 100, white), 1, censor.Adaptive))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			in := image.Pt(x, y).In(image.Rect(75, 45, 125, 55))
+			if got := m.RGBAAt(x, y); in && got != black || !in && got != white {
+				t.Fatalf("pixel (%d, %d) is %v; in the default bar: %v", x, y, got, in)
+			}
+		}
+	}
+}
+
+// TestPrepareExtreme checks that uploads of extreme proportions, very
+// wide or very tall, come through the resizing upload does with every
+// dimension at least 1, in every scaling.
+func TestPrepareExtreme(t *testing.T) {
+	defer func(n int) { MaxDimension = n }(MaxDimension)
+	MaxDimension = 1200
+	for _, tt := range []struct {
+		size, want image.Point
+	}{
+		{image.Pt(5000, 100), image.Pt(600, 12)},
+		{image.Pt(100, 5000), image.Pt(12, 600)},
+		{image.Pt(100000, 1), image.Pt(600, 1)},
+		{image.Pt(1, 100000), image.Pt(1, 600)},
+		{image.Pt(3000, 2), image.Pt(600, 1)},
+	} {
+		for _, how := range []censor.Scaling{censor.Adaptive, censor.Sampled, censor.Smoothed} {
+			m := prepare(solidImage(tt.size.X, tt.size.Y, color.White), 1, how)
+			if got := m.Bounds().Size(); got != tt.want {
+				t.Errorf("%v upload, scaling %q: prepared %v, want %v", tt.size, how, got, tt.want)
+			}
+		}
+	}
+}
+
+// TestThumbnail checks that thumbnails are as wide as asked, in the
+// proportions of the image, and never lose a dimension entirely.
+func TestThumbnail(t *testing.T) {
+	for _, tt := range []struct {
+		size image.Point
+		w    int
+		want image.Point
+	}{
+		{image.Pt(200, 100), 50, image.Pt(50, 25)},
+		{image.Pt(100, 200), 50, image.Pt(50, 100)},
+		{image.Pt(90, 90), 60, image.Pt(60, 60)},
+		{image.Pt(100, 1), 50, image.Pt(50, 1)},
+		{image.Pt(3000, 2), 100, image.Pt(100, 1)},
+		{image.Pt(1, 10), 20, image.Pt(20, 200)},
+	} {
+		m := thumbnail(image.NewRGBA(image.Rectangle{Max: tt.size}), tt.w)
+		if got := m.Bounds().Size(); got != tt.want {
+			t.Errorf("thumbnail of %v at width %d is %v, want %v", tt.size, tt.w, got, tt.want)
+		}
+	}
+}
+
+// TestReusable checks that an upload reuses only a stored image that is
+// still as uploaded, never one edited since.
+func TestReusable(t *testing.T) {
+	data := []byte("image data")
+	im := &Image{Data: data}
+	for _, tt := range []struct {
+		name string
+		old  *Image
+		want bool
+	}{
+		{"pristine", &Image{Data: data}, true},
+		{"edited", &Image{Data: []byte("edited data"), Original: data, Edits: []string{"x=10&y=10"}}, false},
+		{"edited, then undone", &Image{Data: data}, true},
+		{"other data", &Image{Data: []byte("other data")}, false},
+	} {
+		if got := reusable(tt.old, im); got != tt.want {
+			t.Errorf("%s: reusable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	id := keyOf(data)
+	for i, want := range []string{id, id + "-1", id + "-2"} {
+		if got := copyID(id, i); got != want {
+			t.Errorf("copyID(%d) = %q, want %q", i, got, want)
+		}
+		if _, move := rekeyTarget(copyID(id, i), data); move {
+			t.Errorf("rekey would move copy %d", i)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+)
+
+var (
+	srgbOnce    sync.Once
+	srgbProfile []byte
+)
+
+// srgb returns an ICC (version 2) profile describing the sRGB color
+// space. It is built on first use rather than shipped as a file.
+func srgb() []byte {
+	srgbOnce.Do(func() { srgbProfile = buildSRGB() })
+	return srgbProfile
+}
+
+// buildSRGB assembles the sRGB profile: a monitor-class RGB profile with
+// D50-adapted primaries and a tabulated sRGB tone curve shared by the
+// three channels.
+func buildSRGB() []byte {
+	be := binary.BigEndian
+	u32 := func(b *bytes.Buffer, v uint32) { binary.Write(b, be, v) }
+	s15 := func(b *bytes.Buffer, v float64) { u32(b, uint32(int32(math.Floor(v*65536+0.5)))) }
+
+	xyz := func(x, y, z float64) []byte {
+		var b bytes.Buffer
+		b.WriteString("XYZ \x00\x00\x00\x00")
+		s15(&b, x)
+		s15(&b, y)
+		s15(&b, z)
+		return b.Bytes()
+	}
+	desc := func(s string) []byte {
+		var b bytes.Buffer
+		b.WriteString("desc\x00\x00\x00\x00")
+		u32(&b, uint32(len(s)+1))
+		b.WriteString(s)
+		b.WriteByte(0)
+		b.Write(make([]byte, 4+4+2+1+67)) // empty Unicode and ScriptCode descriptions
+		return b.Bytes()
+	}
+	text := func(s string) []byte {
+		var b bytes.Buffer
+		b.WriteString("text\x00\x00\x00\x00")
+		b.WriteString(s)
+		b.WriteByte(0)
+		return b.Bytes()
+	}
+	curve := func() []byte {
+		const n = 1024
+		var b bytes.Buffer
+		b.WriteString("curv\x00\x00\x00\x00")
+		u32(&b, n)
+		for i := 0; i < n; i++ {
+			v := float64(i) / (n - 1)
+			if v <= 0.04045 {
+				v /= 12.92
+			} else {
+				v = math.Pow((v+0.055)/1.055, 2.4)
+			}
+			binary.Write(&b, be, uint16(v*65535+0.5))
+		}
+		return b.Bytes()
+	}
+
+	trc := curve()
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc("sRGB")},
+		{"cprt", text("No copyright, use freely")},
+		{"wtpt", xyz(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	// Lay out the tag data after the header and tag table, 4-byte
+	// aligned. Tags with identical data (the three tone curves) share
+	// one copy of it.
+	var table, data bytes.Buffer
+	offset := 128 + 4 + 12*len(tags)
+	offsets := make(map[string]int)
+	u32(&table, uint32(len(tags)))
+	for _, t := range tags {
+		off, ok := offsets[string(t.data)]
+		if !ok {
+			off = offset + data.Len()
+			offsets[string(t.data)] = off
+			data.Write(t.data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		table.WriteString(t.sig)
+		u32(&table, uint32(off))
+		u32(&table, uint32(len(t.data)))
+	}
+
+	var p bytes.Buffer
+	u32(&p, uint32(offset+data.Len())) // profile size
+	u32(&p, 0)                         // preferred CMM
+	u32(&p, 0x02100000)                // version 2.1
+	p.WriteString("mntrRGB XYZ ")      // class, color space, connection space
+	p.Write(make([]byte, 12))          // creation date
+	p.WriteString("acsp")
+	p.Write(make([]byte, 4+4+4+4+8)) // platform, flags, manufacturer, model, attributes
+	u32(&p, 0)                       // perceptual rendering intent
+	s15(&p, 0.9642)                  // D50 illuminant
+	s15(&p, 1.0)
+	s15(&p, 0.8249)
+	p.Write(make([]byte, 4+16+28)) // creator, profile ID, reserved
+	p.Write(table.Bytes())
+	p.Write(data.Bytes())
+	return p.Bytes()
+}
+
+// embedICC returns a copy of the JPEG data with the ICC profile inserted
+// as an APP2 ICC_PROFILE segment directly after the start-of-image
+// marker.
+func embedICC(jpg, profile []byte) ([]byte, error) {
+	const header = "ICC_PROFILE\x00"
+	if len(jpg) < 2 || jpg[0] != 0xff || jpg[1] != 0xd8 {
+		return nil, errors.New("embedICC: not a JPEG")
+	}
+	// A single segment holds at most 65535 bytes, including its length,
+	// header and chunk numbering; our profile is much smaller than that.
+	n := 2 + len(header) + 2 + len(profile)
+	if n > 0xffff {
+		return nil, errors.New("embedICC: profile too large for a single segment")
+	}
+	var b bytes.Buffer
+	b.Write(jpg[:2])
+	b.Write([]byte{0xff, 0xe2, byte(n >> 8), byte(n)})
+	b.WriteString(header)
+	b.Write([]byte{1, 1}) // chunk 1 of 1
+	b.Write(profile)
+	b.Write(jpg[2:])
+	return b.Bytes(), nil
+}
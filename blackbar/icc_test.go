@@ -0,0 +1,60 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// TestEmbedICC checks that the sRGB profile lands in an APP2 segment
+// right after the start of the JPEG, and that the JPEG still decodes.
+func TestEmbedICC(t *testing.T) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, solidImage(16, 16, color.White), nil); err != nil {
+		t.Fatal(err)
+	}
+	profile := srgb()
+	out, err := embedICC(b.Bytes(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out[:4], []byte{0xff, 0xd8, 0xff, 0xe2}) {
+		t.Fatalf("output starts % x, want an APP2 segment after SOI", out[:4])
+	}
+	n := int(binary.BigEndian.Uint16(out[4:6]))
+	seg := out[6 : 4+n]
+	if want := append([]byte("ICC_PROFILE\x00\x01\x01"), profile...); !bytes.Equal(seg, want) {
+		t.Error("APP2 segment does not hold the profile")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("output does not decode: %v", err)
+	}
+	if _, err := embedICC([]byte("GIF89a"), profile); err == nil {
+		t.Error("embedICC accepted a GIF")
+	}
+}
+
+// TestSRGBProfile checks the header of the sRGB profile.
+func TestSRGBProfile(t *testing.T) {
+	p := srgb()
+	if got := int(binary.BigEndian.Uint32(p)); got != len(p) {
+		t.Errorf("profile says it is %d bytes, but is %d", got, len(p))
+	}
+	if got := string(p[12:24]); got != "mntrRGB XYZ " {
+		t.Errorf("profile class and spaces %q, want \"mntrRGB XYZ \"", got)
+	}
+	if got := string(p[36:40]); got != "acsp" {
+		t.Errorf("profile signature %q, want acsp", got)
+	}
+	// Every tag lies within the profile.
+	n := int(binary.BigEndian.Uint32(p[128:]))
+	for i := 0; i < n; i++ {
+		e := p[132+12*i:]
+		off, size := binary.BigEndian.Uint32(e[4:]), binary.BigEndian.Uint32(e[8:])
+		if int(off+size) > len(p) {
+			t.Errorf("tag %q runs past the end of the profile", e[:4])
+		}
+	}
+}
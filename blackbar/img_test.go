@@ -0,0 +1,104 @@
+package blackbar
+
+import (
+	"bytes"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestUntouched checks which requests img serves the stored bytes for.
+func TestUntouched(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  bool
+	}{
+		{"id=x", true},
+		{"id=x&fmt=jpeg", true},
+		{"id=x&fmt=", true},
+		{"id=x&v=3", true},
+		{"id=x&scope=id&exp=1&sig=abc", true},
+		{"id=x&fmt=jpeg&exp=1&sig=abc&csrf=t", true},
+		{"id=x&fmt=png", false},
+		{"id=x&fmt=jpeg&fmt=jpeg", false},
+		{"id=x&x=10&y=10", false},
+		{"id=x&sig=abc&s=2", false},
+	} {
+		r := httptest.NewRequest("GET", "/img?"+tt.query, nil)
+		if got := untouched(r, "image/jpeg"); got != tt.want {
+			t.Errorf("untouched(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestPathForm checks that an image named by its path reads as the same
+// request as one naming it in the query string.
+func TestPathForm(t *testing.T) {
+	for _, tt := range []struct {
+		path, query string
+	}{
+		{"/img/abc", "id=abc"},
+		{"/img/abc.jpg", "id=abc&fmt=jpeg"},
+		{"/img/abc.png?x=10&y=20", "id=abc&fmt=png&x=10&y=20"},
+		{"/img/abc.webp?fmt=png", "id=abc&fmt=webp"},
+	} {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if !pathForm(r) {
+			t.Errorf("pathForm(%q) failed", tt.path)
+			continue
+		}
+		q := httptest.NewRequest("GET", "/img?"+tt.query, nil)
+		q.ParseForm()
+		if got, want := r.Form.Encode(), q.Form.Encode(); got != want {
+			t.Errorf("pathForm(%q) gives %s, want %s", tt.path, got, want)
+		}
+	}
+	for _, bad := range []string{"/img/", "/img/.jpg", "/img/abc.gif2", "/img/a.b.jpg", "/img/a/b.jpg"} {
+		w := httptest.NewRecorder()
+		imgPath(w, httptest.NewRequest("GET", bad, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("imgPath(%q): got %d, want %d", bad, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+// TestSaveNeedsPOST checks that img refuses to save on a GET, or on a
+// POST without a CSRF token, answering before it touches the store.
+func TestSaveNeedsPOST(t *testing.T) {
+	for _, method := range []string{"GET", "HEAD"} {
+		w := httptest.NewRecorder()
+		img(w, httptest.NewRequest(method, "/img?id=x&x=10&y=10&n=1", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s with n: got %d, want %d", method, w.Code, http.StatusMethodNotAllowed)
+		}
+		if allow := w.Header().Get("Allow"); allow != "POST" {
+			t.Errorf("%s with n: Allow %q, want POST", method, allow)
+		}
+	}
+	w := httptest.NewRecorder()
+	img(w, httptest.NewRequest("POST", "/img?id=x&x=10&y=10&n=1", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("POST with n and no CSRF token: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// TestUntouchedPassthrough checks that a plain request for an image is
+// answered with the stored bytes, byte for byte.
+func TestUntouchedPassthrough(t *testing.T) {
+	defer func(b bool) { ServeDeltas = b }(ServeDeltas)
+	ServeDeltas = false
+	im := &Image{Data: encodeJPEG(t, solidImage(64, 48, color.White))}
+	r := httptest.NewRequest("GET", "/img?id=x&v=2", nil)
+	if !untouched(r, im.contentType()) {
+		t.Fatal("plain request not untouched")
+	}
+	w := httptest.NewRecorder()
+	writeRendition(nil, w, r, im.contentType(), im.Data, renditionTag(im, r))
+	if !bytes.Equal(w.Body.Bytes(), im.Data) {
+		t.Error("stored bytes not served as they are")
+	}
+	if ct := w.Header().Get("Content-type"); ct != "image/jpeg" {
+		t.Errorf("content type %q, want image/jpeg", ct)
+	}
+}
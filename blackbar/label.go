@@ -0,0 +1,45 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"censor"
+	"resize"
+)
+
+// labelBars writes text across the middle of each bar with coordinates,
+// such as "REDACTED" for a redaction log. The text is scaled with the
+// bar, to about two thirds of its height or less if it would be too
+// wide, and is clipped to it. It is drawn in white, or in black on a
+// light fill. Labels are not rotated with their bars.
+func labelBars(dst *image.RGBA, bars []censor.Bar, text string, fill image.Image) {
+	c := color.Color(color.White)
+	if u, ok := fill.(*image.Uniform); ok {
+		r, g, b, _ := u.RGBA()
+		if luminance(uint8(r>>8), uint8(g>>8), uint8(b>>8)) > 128 {
+			c = color.Black
+		}
+	}
+	tile := textTile(text, textHeight, c, color.Transparent)
+	tb := tile.Bounds()
+
+	for _, b := range bars {
+		if b.X <= 0 {
+			continue
+		}
+		r := b.Rect()
+		h := r.Dy() * 2 / 3
+		w := tb.Dx() * h / tb.Dy()
+		if w > r.Dx() {
+			w, h = r.Dx(), tb.Dy()*r.Dx()/tb.Dx()
+		}
+		if w < 1 || h < 1 {
+			continue
+		}
+		m := resize.Resize(tile, tb, w, h)
+		at := image.Rect(0, 0, w, h).Add(image.Pt(b.X-w/2, b.Y-h/2))
+		draw.Draw(dst, at.Intersect(r), m, at.Intersect(r).Min.Sub(at.Min), draw.Over)
+	}
+}
@@ -0,0 +1,41 @@
+package blackbar
+
+import (
+	"image/color"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestLabelBars checks that labels are written within their bars, and
+// that text longer than maxTextLen is refused rather than drawn.
+func TestLabelBars(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	v := url.Values{"x": {"100"}, "y": {"50"}, "s": {"3"}, "text": {"REDACTED"}}
+	dst, err := render(formRequest(v), &Image{}, solidImage(200, 100, red))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bar is 200 by 40, from (0, 30) to (200, 70).
+	var white int
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			c := dst.RGBAAt(x, y)
+			if c.R == 0xff && c.G == 0xff && c.B == 0xff {
+				if y < 30 || y >= 70 {
+					t.Fatalf("label drawn at (%d, %d), outside the bar", x, y)
+				}
+				white++
+			}
+		}
+	}
+	if white == 0 {
+		t.Error("bar not labeled")
+	}
+	for _, n := range []int{maxTextLen + 1, 10 << 20} {
+		v.Set("text", strings.Repeat("x", n))
+		if _, err := render(formRequest(v), &Image{}, solidImage(200, 100, red)); err == nil {
+			t.Errorf("label of %d bytes accepted, want an error", n)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"net/http"
+
+	"appengine"
+	"appengine/datastore"
+	"censor"
+	"resize"
+)
+
+// Bounds on the pages list shows.
+const (
+	listLimit     = 50
+	listThumbSize = 100 // pixels, in either dimension
+)
+
+// ListEntry is an image as shown by list.
+type ListEntry struct {
+	ID    string
+	Thumb string // a small JPEG version, as a data URI
+}
+
+// list is the HTTP handler for browsing stored images; it handles
+// "/list". It shows a page of thumbnails linking to the editor, with a
+// link to the next page if there is one, and a button to delete each.
+// Every stored image is listed, in key order, and thumbnails are made
+// from the image data on the fly.
+func list(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	q := datastore.NewQuery("Image").Limit(listLimit)
+	if s := r.FormValue("cursor"); s != "" {
+		cursor, err := datastore.DecodeCursor(s)
+		if err != nil {
+			http.Error(w, "bad cursor", http.StatusBadRequest)
+			return
+		}
+		q = q.Start(cursor)
+	}
+
+	d := newPageData()
+	d.CSRF = csrfToken(w, r)
+	t := q.Run(c)
+	for {
+		var im Image
+		key, err := t.Next(&im)
+		if err == datastore.Done {
+			break
+		}
+		check(err)
+		e := ListEntry{ID: key.StringID()}
+		if m, _, err := image.Decode(bytes.NewReader(im.Data)); err != nil {
+			c.Warningf("list: %s: %v", e.ID, err)
+		} else {
+			b := m.Bounds()
+			tw, th := censor.FitWithin(b, listThumbSize, listThumbSize)
+			var buf bytes.Buffer
+			check(jpeg.Encode(&buf, resize.Resize(m, b, tw, th), nil))
+			e.Thumb = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+		d.Images = append(d.Images, e)
+	}
+	if len(d.Images) == listLimit {
+		cursor, err := t.Cursor()
+		check(err)
+		d.Cursor = cursor.String()
+	}
+	templateSet().ExecuteTemplate(w, "list.html", d)
+}
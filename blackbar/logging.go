@@ -0,0 +1,107 @@
+package blackbar
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"appengine"
+)
+
+// logged wraps fn so that every request it handles is logged, once it
+// is done, as a single line of key=value pairs: the method, path, image
+// id and bar, the size of the decoded image, the status and how long it
+// all took. It goes outside errorHandler, so the status logged is the
+// one the client got:
+//
+//	http.HandleFunc("/img", logged(errorHandler(img)))
+func logged(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &logWriter{ResponseWriter: w}
+		fn(lw, r)
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "method=%s path=%s", r.Method, r.URL.Path)
+		form := loggedForm(r)
+		for _, k := range []string{"id", "x", "y", "s", "mode"} {
+			if v := form.Get(k); v != "" {
+				fmt.Fprintf(&b, " %s=%q", k, v)
+			}
+		}
+		if lw.size != image.ZP {
+			fmt.Fprintf(&b, " size=%dx%d", lw.size.X, lw.size.Y)
+		}
+		fmt.Fprintf(&b, " status=%d duration=%s", lw.status, time.Since(start))
+		if lw.err != nil {
+			fmt.Fprintf(&b, " error=%q", lw.err.Error())
+		}
+		appengine.NewContext(r).Infof("%s", b.String())
+	}
+}
+
+// loggedForm returns the form values of r to log: those the handler
+// parsed, or else those of the URL. The body is never read here, since
+// the handler may have refused it for being too large.
+func loggedForm(r *http.Request) url.Values {
+	if r.Form != nil {
+		return r.Form
+	}
+	return r.URL.Query()
+}
+
+// logWriter is the http.ResponseWriter logged hands its handler, which
+// records what logged reports.
+type logWriter struct {
+	http.ResponseWriter
+	status int
+	size   image.Point // of the decoded image, if noted
+	err    error       // the failure, if any, errorHandler caught
+}
+
+func (w *logWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Hijack passes on the connection, for liveEdit's WebSocket.
+func (w *logWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T cannot be hijacked", w.ResponseWriter)
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// noteSize records, for the log, the size of the image a request works
+// on. w is the writer the handler was given.
+func noteSize(w http.ResponseWriter, size image.Point) {
+	if lw, ok := w.(*logWriter); ok {
+		lw.size = size
+	}
+}
+
+// noteError records, for the log, the error a request failed with.
+func noteError(w http.ResponseWriter, err error) {
+	if lw, ok := w.(*logWriter); ok {
+		lw.err = err
+	}
+}
@@ -0,0 +1,45 @@
+package blackbar
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	strings.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func (r *countingReader) Close() error { return nil }
+
+// TestLoggedFormLeavesBody checks that logging a request whose body the
+// handler refused does not read the body after all.
+func TestLoggedFormLeavesBody(t *testing.T) {
+	body := &countingReader{Reader: *strings.NewReader("id=fromform")}
+	r, err := http.NewRequest("POST", "/?id=abc&x=10", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	form := loggedForm(r)
+	if body.n != 0 {
+		t.Errorf("read %d bytes of the body", body.n)
+	}
+	if form.Get("id") != "abc" || form.Get("x") != "10" {
+		t.Errorf("loggedForm = %v, want the query's values", form)
+	}
+
+	// Once the handler has parsed the form, that is what is logged.
+	r.ParseForm()
+	if got := loggedForm(r)["id"]; len(got) != 2 {
+		t.Errorf("loggedForm after parsing has id %v, want both values", got)
+	}
+}
@@ -0,0 +1,47 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+
+	"appengine"
+	"censor"
+	"resize"
+)
+
+// Bounds on the placeholders served by lqip.
+const (
+	lqipSize     = 16   // pixels, in either dimension
+	lqipMaxBytes = 2048 // of the data URI
+)
+
+// lqip is the HTTP handler for low-quality image placeholders; it
+// handles "/lqip". It serves, as text, a data URI for a tiny blurred
+// version of the image, small enough to inline in a page and stretch
+// over the image's box until the real thing loads.
+func lqip(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	m, _, err := load(c, r.FormValue("id"))
+	check(err)
+
+	uri := placeholderURI(m)
+	if len(uri) > lqipMaxBytes {
+		check(fmt.Errorf("placeholder is %d bytes, over the limit of %d", len(uri), lqipMaxBytes))
+	}
+	w.Header().Set("Content-type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, uri)
+}
+
+// placeholderURI returns the data URI lqip serves for m.
+func placeholderURI(m image.Image) string {
+	tw, th := censor.FitWithin(m.Bounds(), lqipSize, lqipSize)
+	small := censor.RGBA(resize.Resize(m, m.Bounds(), tw, th))
+	censor.BoxBlur(small, small.Bounds(), 1)
+	var buf bytes.Buffer
+	check(jpeg.Encode(&buf, small, &jpeg.Options{Quality: 30}))
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,41 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+// TestPlaceholderURI checks that the placeholder of a large, detailed
+// image is small, decodes, and keeps the image's proportions.
+func TestPlaceholderURI(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 1200, 800))
+	for y := 0; y < 800; y++ {
+		for x := 0; x < 1200; x++ {
+			m.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 0xff})
+		}
+	}
+	uri := placeholderURI(m)
+	if len(uri) > lqipMaxBytes {
+		t.Errorf("placeholder is %d bytes, over %d", len(uri), lqipMaxBytes)
+	}
+	const prefix = "data:image/jpeg;base64,"
+	if !strings.HasPrefix(uri, prefix) {
+		t.Fatalf("placeholder %.40q... is not a JPEG data URI", uri)
+	}
+	data, err := base64.StdEncoding.DecodeString(uri[len(prefix):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Bounds(), image.Rect(0, 0, 16, 10); got != want {
+		t.Errorf("placeholder is %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package blackbar
+
+// Metrics of uploads and renderings, served at /metrics in the
+// Prometheus text format. They are counted by each instance from its
+// start, so a scraper sees those of whichever instance answers.
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// renderBuckets are the upper bounds, in seconds, of the buckets of the
+// render latency histogram.
+var renderBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// metrics holds the counts. Handlers update it through the count
+// functions below, from any number of requests at once.
+var metrics = struct {
+	sync.Mutex
+	uploads        int64
+	decodeFailures int64
+	storedBytes    int64
+	renders        map[string]int64 // by mode
+	renderSeconds  float64          // total
+	renderBuckets  []int64          // counts, one for each of renderBuckets
+	failures       map[int]int64    // by status
+}{
+	renders:       make(map[string]int64),
+	renderBuckets: make([]int64, len(renderBuckets)),
+	failures:      make(map[int]int64),
+}
+
+// countUpload counts an upload, and whether it could be decoded.
+func countUpload(decoded bool) {
+	metrics.Lock()
+	metrics.uploads++
+	if !decoded {
+		metrics.decodeFailures++
+	}
+	metrics.Unlock()
+}
+
+// countStored counts n bytes of image data stored.
+func countStored(n int) {
+	metrics.Lock()
+	metrics.storedBytes += int64(n)
+	metrics.Unlock()
+}
+
+// countRender counts a rendering in the given mode that took d.
+func countRender(mode string, d time.Duration) {
+	if mode == "" {
+		mode = "bar"
+	}
+	metrics.Lock()
+	metrics.renders[mode]++
+	s := d.Seconds()
+	metrics.renderSeconds += s
+	for i, le := range renderBuckets {
+		if s <= le {
+			metrics.renderBuckets[i]++
+		}
+	}
+	metrics.Unlock()
+}
+
+// countFailure counts a request that failed with the given status.
+func countFailure(status int) {
+	metrics.Lock()
+	metrics.failures[status]++
+	metrics.Unlock()
+}
+
+// serveMetrics is the HTTP handler for the metrics; it handles
+// "/metrics".
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	w.Header().Set("Content-type", "text/plain; version=0.0.4")
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("blackbar_uploads_total", "counter", "Images uploaded.")
+	fmt.Fprintf(w, "blackbar_uploads_total %d\n", metrics.uploads)
+	metric("blackbar_upload_decode_failures_total", "counter", "Uploads that could not be decoded.")
+	fmt.Fprintf(w, "blackbar_upload_decode_failures_total %d\n", metrics.decodeFailures)
+	metric("blackbar_stored_bytes_total", "counter", "Bytes of image data stored.")
+	fmt.Fprintf(w, "blackbar_stored_bytes_total %d\n", metrics.storedBytes)
+
+	metric("blackbar_renders_total", "counter", "Images rendered by img, by mode.")
+	var modes []string
+	for m := range metrics.renders {
+		modes = append(modes, m)
+	}
+	sort.Strings(modes)
+	for _, m := range modes {
+		fmt.Fprintf(w, "blackbar_renders_total{mode=%q} %d\n", m, metrics.renders[m])
+	}
+
+	metric("blackbar_render_seconds", "histogram", "Time img takes to render and encode an image.")
+	var n int64
+	for _, c := range metrics.renders {
+		n += c
+	}
+	for i, le := range renderBuckets {
+		fmt.Fprintf(w, "blackbar_render_seconds_bucket{le=\"%g\"} %d\n", le, metrics.renderBuckets[i])
+	}
+	fmt.Fprintf(w, "blackbar_render_seconds_bucket{le=\"+Inf\"} %d\n", n)
+	fmt.Fprintf(w, "blackbar_render_seconds_sum %g\n", metrics.renderSeconds)
+	fmt.Fprintf(w, "blackbar_render_seconds_count %d\n", n)
+
+	metric("blackbar_failures_total", "counter", "Requests that failed, by status.")
+	var statuses []int
+	for s := range metrics.failures {
+		statuses = append(statuses, s)
+	}
+	sort.Ints(statuses)
+	for _, s := range statuses {
+		fmt.Fprintf(w, "blackbar_failures_total{status=\"%d\"} %d\n", s, metrics.failures[s])
+	}
+}
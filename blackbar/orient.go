@@ -0,0 +1,112 @@
+package blackbar
+
+// Coordinates.
+//
+// Bar and other coordinates are always in the frame of the stored image,
+// which is upright: upload applies any EXIF orientation before storing a
+// photo, and records which one in Image.Orientation (served by /meta).
+// A client that captured coordinates against the image as it was shot,
+// before that rotation, can pass orient=original to have them mapped.
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"net/http"
+
+	"appengine"
+	"censor"
+)
+
+// orientPoint maps p from the frame of an image as shot, of the given
+// size, to the upright frame produced by applying EXIF orientation o.
+func orientPoint(p image.Point, o int, size image.Point) image.Point {
+	w, h := size.X-1, size.Y-1
+	switch o {
+	case 2: // mirrored horizontally
+		return image.Pt(w-p.X, p.Y)
+	case 3: // rotated 180°
+		return image.Pt(w-p.X, h-p.Y)
+	case 4: // mirrored vertically
+		return image.Pt(p.X, h-p.Y)
+	case 5: // transposed
+		return image.Pt(p.Y, p.X)
+	case 6: // needs rotating 90° clockwise
+		return image.Pt(h-p.Y, p.X)
+	case 7: // transversed
+		return image.Pt(h-p.Y, w-p.X)
+	case 8: // needs rotating 90° counter-clockwise
+		return image.Pt(p.Y, w-p.X)
+	}
+	return p
+}
+
+// orientAngle maps a, the angle of a bar in the frame of an image as
+// shot, to the upright frame produced by applying EXIF orientation o.
+// Bars look the same turned half way round, so angles are mapped only
+// up to a multiple of 180°.
+func orientAngle(a float64, o int) float64 {
+	switch o {
+	case 2, 4: // mirrored
+		return -a
+	case 5, 7: // mirrored across a diagonal
+		return 90 - a
+	case 6, 8: // rotated a quarter turn
+		return a + 90
+	}
+	return a
+}
+
+// upright returns m, an image as shot, turned upright by applying EXIF
+// orientation o. Images needing nothing done are returned as they are.
+func upright(m image.Image, o int) image.Image {
+	if o < 2 || o > 8 {
+		return m
+	}
+	src := censor.RGBA(m)
+	b := src.Bounds()
+	size := b.Size()
+	dst := image.NewRGBA(image.Rectangle{Max: shotSize(size, o)})
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			p := orientPoint(image.Pt(x, y), o, size)
+			copy(dst.Pix[dst.PixOffset(p.X, p.Y):][:4], src.Pix[src.PixOffset(b.Min.X+x, b.Min.Y+y):])
+		}
+	}
+	return dst
+}
+
+// shotSize returns the size of an image as shot, given its upright size
+// and the orientation that was applied to it.
+func shotSize(upright image.Point, o int) image.Point {
+	if o >= 5 && o <= 8 {
+		return image.Pt(upright.Y, upright.X)
+	}
+	return upright
+}
+
+// meta is the HTTP handler for image metadata; it handles "/meta" and
+// "/info". It reports, as JSON, the dimensions and format of the stored
+// image, its size in bytes, and the EXIF orientation that was applied
+// to make it upright (1 if none). Only the image's header is decoded,
+// not its pixels.
+func meta(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	im, key, err := fetch(c, r.FormValue("id"))
+	check(err)
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(im.Data))
+	check(err)
+	res := struct {
+		ID          string `json:"id"`
+		Width       int    `json:"width"`
+		Height      int    `json:"height"`
+		Format      string `json:"format"`
+		Bytes       int    `json:"bytes"`
+		Orientation int    `json:"orientation"`
+	}{key.StringID(), cfg.Width, cfg.Height, format, len(im.Data), im.Orientation}
+	if res.Orientation == 0 {
+		res.Orientation = 1
+	}
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
+}
@@ -0,0 +1,111 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// TestOrientPoint checks that orientPoint says where upright moves each
+// pixel, for every orientation.
+func TestOrientPoint(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i)
+	}
+	size := m.Bounds().Size()
+	for o := 1; o <= 8; o++ {
+		dst := upright(m, o)
+		if got, want := shotSize(dst.Bounds().Size(), o), size; got != want {
+			t.Errorf("orientation %d: shotSize = %v, want %v", o, got, want)
+		}
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				p := orientPoint(image.Pt(x, y), o, size)
+				if got, want := dst.At(p.X, p.Y), m.At(x, y); got != want {
+					t.Errorf("orientation %d: pixel (%d, %d) went to %v, which holds %v, want %v", o, x, y, p, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestOrientOriginalBars checks that orient=original paints a bar given
+// in the frame of the image as shot over the same pixels once upright,
+// whichever way the image was turned.
+func TestOrientOriginalBars(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	shot := image.Pt(200, 100)
+	// A 50 by 10 bar, from (75, 45) to (125, 55) in the shot.
+	v := url.Values{"x": {"100"}, "y": {"50"}, "s": {"0"}, "orient": {"original"}}
+	for o := 1; o <= 8; o++ {
+		size := shotSize(shot, o) // its own inverse
+		dst, err := render(formRequest(v), &Image{Orientation: o}, solidImage(size.X, size.Y, white))
+		if err != nil {
+			t.Fatalf("orientation %d: %v", o, err)
+		}
+		p, q := orientPoint(image.Pt(75, 45), o, shot), orientPoint(image.Pt(124, 54), o, shot)
+		want := image.Rectangle{p, q}.Canon()
+		want.Max = want.Max.Add(image.Pt(1, 1))
+
+		var got image.Rectangle
+		n := 0
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				if dst.RGBAAt(x, y) != white {
+					got = got.Union(image.Rect(x, y, x+1, y+1))
+					n++
+				}
+			}
+		}
+		if n != 500 {
+			t.Errorf("orientation %d: painted %d pixels, want 500", o, n)
+		}
+		// The center of an even-sized bar is half a pixel off, so the
+		// bar may land a pixel away.
+		for _, d := range []int{
+			got.Min.X - want.Min.X, got.Min.Y - want.Min.Y,
+			got.Max.X - want.Max.X, got.Max.Y - want.Max.Y,
+		} {
+			if d < -1 || d > 1 {
+				t.Errorf("orientation %d: painted %v, want %v", o, got, want)
+				break
+			}
+		}
+	}
+}
+
+// TestOrientAngle checks that orient=original turns tilted bars too,
+// by checking the painted bar lies along the mapped direction.
+func TestOrientAngle(t *testing.T) {
+	for _, tt := range []struct {
+		o    int
+		a    float64
+		want float64
+	}{
+		{1, 30, 30},
+		{2, 30, -30},
+		{3, 30, 30},
+		{5, 30, 60},
+		{6, 30, 120},
+		{8, 0, 90},
+	} {
+		got := orientAngle(tt.a, tt.o)
+		if d := got - tt.want; d != 0 && d != 180 && d != -180 {
+			t.Errorf("orientAngle(%v, %d) = %v, want %v", tt.a, tt.o, got, tt.want)
+		}
+	}
+	// A bar along the shot's diagonal, in a 100 by 100 shot, is along
+	// the other diagonal once mirrored.
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	v := url.Values{"x": {"50"}, "y": {"50"}, "s": {"0"}, "a": {strconv.Itoa(45)}, "orient": {"original"}}
+	dst, err := render(formRequest(v), &Image{Orientation: 2}, solidImage(100, 100, white))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.RGBAAt(35, 65) == white || dst.RGBAAt(35, 35) != white {
+		t.Error("mirrored bar not turned to the other diagonal")
+	}
+}
@@ -0,0 +1,103 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// output describes how a rendered image is encoded for the client, as
+// selected by the fmt and q form values.
+type output struct {
+	Format  string // "jpeg" (the default), "png", "avif", "webp" or "pdf"
+	Quality int    // 1 to 100, or 0 for the encoder's default
+	Caption string // set below the image, for PDF
+}
+
+// extFormats maps file name extensions to the formats they select.
+var extFormats = map[string]string{
+	"jpg":  "jpeg",
+	"jpeg": "jpeg",
+	"png":  "png",
+	"avif": "avif",
+	"webp": "webp",
+	"pdf":  "pdf",
+}
+
+// outputOf returns the output requested by r.
+func outputOf(r *http.Request) output {
+	o := output{Format: r.FormValue("fmt"), Caption: r.FormValue("caption")}
+	if q, err := strconv.Atoi(r.FormValue("q")); err == nil {
+		if q < 1 {
+			q = 1
+		} else if q > 100 {
+			q = 100
+		}
+		o.Quality = q
+	}
+	return o
+}
+
+// contentType returns the type of what encode writes for o.
+func (o output) contentType() string {
+	switch o.Format {
+	case "png":
+		return "image/png"
+	case "avif":
+		if encodeAVIF != nil {
+			return "image/avif"
+		}
+	case "webp":
+		if encodeWebP != nil {
+			return "image/webp"
+		}
+	case "pdf":
+		return "application/pdf"
+	}
+	return "image/jpeg"
+}
+
+// encode writes m to w and returns its content type. Formats this build
+// cannot write fall back to JPEG.
+func (o output) encode(w io.Writer, m image.Image) (string, error) {
+	switch o.Format {
+	case "png": // lossless, and keeps transparency
+		return "image/png", png.Encode(w, m)
+	case "avif":
+		if encodeAVIF != nil {
+			return "image/avif", encodeAVIF(w, m, o.Quality)
+		}
+	case "webp":
+		if encodeWebP != nil {
+			return "image/webp", encodeWebP(w, m, o.Quality)
+		}
+	case "pdf":
+		var buf bytes.Buffer
+		if _, err := (output{Quality: o.Quality}).encode(&buf, m); err != nil {
+			return "", err
+		}
+		b := m.Bounds()
+		return "application/pdf", writePDF(w, buf.Bytes(), b.Dx(), b.Dy(), o.Caption)
+	}
+	return "image/jpeg", jpeg.Encode(w, m, o.jpegOptions())
+}
+
+// jpegOptions returns the options for encoding o as JPEG.
+func (o output) jpegOptions() *jpeg.Options {
+	if o.Quality == 0 {
+		return nil // the default quality
+	}
+	return &jpeg.Options{Quality: o.Quality}
+}
+
+// encodeAVIF writes m to w as AVIF. It is nil unless an encoder was
+// compiled in (see avif.go).
+var encodeAVIF func(w io.Writer, m image.Image, quality int) error
+
+// encodeWebP writes m to w as WebP. It is nil unless an encoder was
+// compiled in (see webp.go).
+var encodeWebP func(w io.Writer, m image.Image, quality int) error
@@ -0,0 +1,56 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/url"
+	"testing"
+)
+
+func TestOutputOf(t *testing.T) {
+	for _, tt := range []struct {
+		q    string
+		want int
+	}{{"", 0}, {"50", 50}, {"0", 1}, {"-3", 1}, {"101", 100}, {"x", 0}} {
+		if got := outputOf(formRequest(url.Values{"q": {tt.q}})).Quality; got != tt.want {
+			t.Errorf("q=%q: quality %d, want %d", tt.q, got, tt.want)
+		}
+	}
+}
+
+// TestEncodeFallback checks that formats this build cannot write fall
+// back to JPEG, saying so in the content type, and that q sets the JPEG
+// quality.
+func TestEncodeFallback(t *testing.T) {
+	defer func(a, w func(io.Writer, image.Image, int) error) {
+		encodeAVIF, encodeWebP = a, w
+	}(encodeAVIF, encodeWebP)
+	encodeAVIF, encodeWebP = nil, nil
+
+	m := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 31)
+	}
+	for _, f := range []string{"", "jpeg", "avif", "webp", "bmp"} {
+		o := output{Format: f}
+		var b bytes.Buffer
+		ctype, err := o.encode(&b, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctype != "image/jpeg" || o.contentType() != "image/jpeg" {
+			t.Errorf("fmt=%s: content type %q, %q, want image/jpeg", f, ctype, o.contentType())
+		}
+		if _, err := jpeg.Decode(&b); err != nil {
+			t.Errorf("fmt=%s: %v", f, err)
+		}
+	}
+	var lo, hi bytes.Buffer
+	output{Format: "avif", Quality: 10}.encode(&lo, m)
+	output{Format: "avif", Quality: 95}.encode(&hi, m)
+	if lo.Len() >= hi.Len() {
+		t.Errorf("q=10 gave %d bytes, no fewer than the %d of q=95", lo.Len(), hi.Len())
+	}
+}
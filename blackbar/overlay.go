@@ -0,0 +1,84 @@
+package blackbar
+
+// Overlays: images of the user's own, such as a sticker or a logo, that
+// img places where bars would go.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"net/http"
+
+	"appengine"
+	"appengine/datastore"
+	"censor"
+	"resize"
+)
+
+// uploadOverlay is the HTTP handler for uploading overlays; it handles
+// "/overlay". The "image" file of the form is stored as an image of its
+// own, as PNG so that transparency survives, and without DefaultBars.
+// The reply is {"id": ...}, for the overlay form value of img.
+func uploadOverlay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "uploading an overlay requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !parseUpload(w, r, "Images") {
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	f, _, err := r.FormFile("image")
+	check(badRequest(err))
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	check(badRequest(err))
+	m, _, err := image.Decode(bytes.NewReader(data))
+	check(badRequest(err))
+	m = censor.Shrink(m, MaxDimension, censor.Adaptive)
+	key := put(appengine.NewContext(r), &Image{ContentType: "image/png"}, m, 0)
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(struct {
+		ID string `json:"id"`
+	}{key.StringID()}))
+}
+
+// loadOverlay returns the overlay stored under id, decoded, or nil if id
+// is empty. An id with nothing stored under it is the client's error.
+func loadOverlay(c appengine.Context, id string) (image.Image, error) {
+	if id == "" {
+		return nil, nil
+	}
+	m, _, err := load(c, id)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, badRequest(fmt.Errorf("there is no overlay %s", id))
+	}
+	return m, err
+}
+
+// drawOverlay composites overlay onto dst, scaled to fit within the
+// part of the bar b on dst, keeping its proportions, and centered on that
+// part. Transparent parts of the overlay let the picture show through.
+// The overlay is never scaled past the edges of dst, however large the
+// bar, so a long thin overlay costs no more than the picture.
+func drawOverlay(dst *image.RGBA, overlay image.Image, b censor.Bar) {
+	fit := b.Rect().Intersect(dst.Bounds())
+	if fit.Empty() {
+		return
+	}
+	o := overlay
+	ob := o.Bounds()
+	if w, h := censor.FitWithin(ob, fit.Dx(), fit.Dy()); w != ob.Dx() || h != ob.Dy() {
+		o = resize.Resize(o, ob, w, h)
+		ob = o.Bounds()
+	}
+	c := fit.Min.Add(fit.Max).Div(2)
+	at := ob.Sub(ob.Min).Add(image.Pt(c.X-ob.Dx()/2, c.Y-ob.Dy()/2))
+	draw.Draw(dst, at, o, ob.Min, draw.Over)
+}
@@ -0,0 +1,115 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"censor"
+)
+
+// TestDrawOverlay checks that transparent pixels of an overlay leave
+// the picture under them unchanged, that opaque ones replace it, and
+// that translucent ones blend with it.
+func TestDrawOverlay(t *testing.T) {
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	// A 50 by 10 overlay, the size of the bar, so it is not scaled:
+	// transparent on the left, translucent in the middle, opaque on
+	// the right.
+	o := image.NewRGBA(image.Rect(0, 0, 50, 10))
+	for y := 0; y < 10; y++ {
+		for x := 20; x < 30; x++ {
+			o.SetRGBA(x, y, color.RGBA{0, 0, 0x80, 0x80})
+		}
+		for x := 30; x < 50; x++ {
+			o.SetRGBA(x, y, blue)
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			dst.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0x40, 0xff})
+		}
+	}
+	orig := cloneRGBA(dst)
+	// The bar, and the overlay, cover (75, 45) to (125, 55).
+	drawOverlay(dst, o, censor.Bar{X: 100, Y: 50, S: 0})
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			got, was := dst.RGBAAt(x, y), orig.RGBAAt(x, y)
+			in := y >= 45 && y < 55
+			switch {
+			case in && x >= 105 && x < 125:
+				if got != blue {
+					t.Fatalf("pixel (%d, %d) under opaque overlay is %v, want %v", x, y, got, blue)
+				}
+			case in && x >= 95 && x < 105:
+				want := color.RGBA{was.R / 2, was.G / 2, 0x80 + was.B/2, 0xff}
+				if !near(got, want) {
+					t.Fatalf("pixel (%d, %d) under translucent overlay is %v, want about %v", x, y, got, want)
+				}
+			default:
+				if got != was {
+					t.Fatalf("pixel (%d, %d) is %v, want it unchanged, %v", x, y, got, was)
+				}
+			}
+		}
+	}
+}
+
+// TestDrawOverlayFits checks that an overlay is fitted within the part
+// of the bar on the picture, on both axes, rather than scaled to the
+// width of a bar far larger than the picture.
+func TestDrawOverlayFits(t *testing.T) {
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	for _, tt := range []struct {
+		size image.Point // of the overlay
+		b    censor.Bar
+		want image.Rectangle
+	}{
+		// Tall and thin, on a bar covering the whole picture: a
+		// single column, the height of the picture.
+		{image.Pt(1, 1200), censor.Bar{X: 100, Y: 50, S: 1000}, image.Rect(100, 0, 101, 100)},
+		// Wide, on a bar running off the right edge: fitted to the
+		// part from (165, 45) to (200, 55), and centered on it.
+		{image.Pt(100, 10), censor.Bar{X: 190, Y: 50, S: 0}, image.Rect(165, 49, 200, 52)},
+		// Square, on a bar within the picture: as high as the bar.
+		{image.Pt(40, 40), censor.Bar{X: 100, Y: 50, S: 0}, image.Rect(95, 45, 105, 55)},
+	} {
+		o := image.NewRGBA(image.Rectangle{Max: tt.size})
+		for i := 0; i < len(o.Pix); i += 4 {
+			copy(o.Pix[i:], []uint8{0, 0, 0xff, 0xff})
+		}
+		dst := solidImage(200, 100, white)
+		drawOverlay(dst, o, tt.b)
+		var got image.Rectangle
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 200; x++ {
+				switch dst.RGBAAt(x, y) {
+				case white:
+				case blue:
+					got = got.Union(image.Rect(x, y, x+1, y+1))
+				default:
+					t.Fatalf("overlay %v: pixel (%d, %d) neither blue nor white", tt.size, x, y)
+				}
+			}
+		}
+		if got != tt.want {
+			t.Errorf("overlay %v on %v: drawn over %v, want %v", tt.size, tt.b.Rect(), got, tt.want)
+		}
+	}
+}
+
+// near reports whether c and d differ by at most one in each channel,
+// as rounding may leave them.
+func near(c, d color.RGBA) bool {
+	for _, v := range []int{
+		int(c.R) - int(d.R), int(c.G) - int(d.G), int(c.B) - int(d.B), int(c.A) - int(d.A),
+	} {
+		if v < -1 || v > 1 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package blackbar
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// pdfCaptionHeight is the height, in points, of the band below the image
+// that holds a PDF's caption.
+const pdfCaptionHeight = 20
+
+// writePDF writes a single page PDF to w showing jpg, a width by height
+// pixel JPEG image, one point per pixel. The JPEG is embedded as it is,
+// since PDF can decode it (DCTDecode). If caption is not empty, it is
+// set in a band below the image.
+func writePDF(w io.Writer, jpg []byte, width, height int, caption string) error {
+	band := 0
+	if caption != "" {
+		band = pdfCaptionHeight
+	}
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "q %d 0 0 %d 0 %d cm /Im0 Do Q\n", width, height, band)
+	if caption != "" {
+		fmt.Fprintf(&content, "BT /F1 10 Tf 4 6 Td (%s) Tj ET\n", pdfString(caption))
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /XObject << /Im0 4 0 R >> /Font << /F1 6 0 R >> >> /Contents 5 0 R >>",
+			width, height+band),
+		fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d "+
+			"/ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n%s\nendstream",
+			width, height, len(jpg), jpg),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.Bytes()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// pdfString escapes s for use in a PDF literal string. The standard
+// fonts only cover ASCII here, so anything else becomes '?'.
+func pdfString(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '?'
+		}
+		return r
+	}, strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(s))
+}
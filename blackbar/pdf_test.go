@@ -0,0 +1,116 @@
+package blackbar
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parsePDF checks that p is a well formed PDF of the kind writePDF
+// writes: a header, objects where the cross-reference table says, and a
+// trailer pointing at that table. It returns the objects by number.
+func parsePDF(t *testing.T, p []byte) map[int]string {
+	if !bytes.HasPrefix(p, []byte("%PDF-1.")) {
+		t.Fatalf("no PDF header: %q", p[:10])
+	}
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(p)
+	if m == nil {
+		t.Fatal("no startxref at the end")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if xref >= len(p) || !bytes.HasPrefix(p[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	lines := strings.Split(string(p[xref:]), "\n")
+	var first, n int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &n); err != nil || first != 0 {
+		t.Fatalf("bad xref subsection %q", lines[1])
+	}
+	if !strings.Contains(string(p[xref:]), fmt.Sprintf("/Size %d", n)) {
+		t.Errorf("trailer /Size does not match the %d xref entries", n)
+	}
+	objects := make(map[int]string)
+	for i := 1; i < n; i++ {
+		e := lines[2+i]
+		if len(e) != 19 || !strings.HasSuffix(e, " 00000 n ") {
+			t.Fatalf("bad xref entry %q", e)
+		}
+		off, _ := strconv.Atoi(e[:10])
+		head := fmt.Sprintf("%d 0 obj\n", i)
+		if !bytes.HasPrefix(p[off:], []byte(head)) {
+			t.Fatalf("object %d not at offset %d", i, off)
+		}
+		end := bytes.Index(p[off:], []byte("\nendobj\n"))
+		if end < 0 {
+			t.Fatalf("object %d not ended", i)
+		}
+		objects[i] = string(p[off+len(head) : off+end])
+	}
+	return objects
+}
+
+// TestWritePDF checks that the PDF holds one page, sized to the image,
+// showing the JPEG as given.
+func TestWritePDF(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, solidImage(120, 80, color.Black), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		caption string
+		height  int
+	}{
+		{"", 80},
+		{"Exhibit (A) \\ redacted", 80 + pdfCaptionHeight},
+	} {
+		var buf bytes.Buffer
+		if err := writePDF(&buf, jpg.Bytes(), 120, 80, tt.caption); err != nil {
+			t.Fatal(err)
+		}
+		objects := parsePDF(t, buf.Bytes())
+		var pages int
+		for _, o := range objects {
+			if strings.Contains(o, "/Type /Page ") {
+				pages++
+				if want := fmt.Sprintf("/MediaBox [0 0 120 %d]", tt.height); !strings.Contains(o, want) {
+					t.Errorf("caption %q: page %s, want %s", tt.caption, o, want)
+				}
+			}
+		}
+		if pages != 1 || !strings.Contains(objects[2], "/Count 1") {
+			t.Errorf("caption %q: %d pages, want 1", tt.caption, pages)
+		}
+		im := objects[4]
+		i := strings.Index(im, "stream\n")
+		if i < 0 {
+			t.Fatalf("caption %q: no image stream", tt.caption)
+		}
+		data := strings.TrimSuffix(im[i+len("stream\n"):], "\nendstream")
+		if data != jpg.String() {
+			t.Errorf("caption %q: image stream is not the JPEG", tt.caption)
+		}
+		if !strings.Contains(im, fmt.Sprintf("/Length %d", len(data))) {
+			t.Errorf("caption %q: image /Length does not match the stream", tt.caption)
+		}
+		if c := objects[5]; tt.caption != "" && !strings.Contains(c, `(Exhibit \(A\) \\ redacted) Tj`) {
+			t.Errorf("caption %q: content %q does not set it", tt.caption, c)
+		}
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"plain", "plain"},
+		{`a (b) \c`, `a \(b\) \\c`},
+		{"café\n", "caf??"},
+	} {
+		if got := pdfString(tt.in); got != tt.want {
+			t.Errorf("pdfString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"sync"
+)
+
+var (
+	placeholderOnce sync.Once
+	placeholderData []byte
+)
+
+// servePlaceholder writes the placeholder image with a 404 status.
+func servePlaceholder(w http.ResponseWriter) {
+	placeholderOnce.Do(func() {
+		placeholderData = Placeholder
+		if placeholderData == nil {
+			placeholderData = unavailable()
+		}
+	})
+	w.Header().Set("Content-type", http.DetectContentType(placeholderData))
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(placeholderData)
+}
+
+// unavailable returns a JPEG of a light gray box with a cross through it,
+// the universal sign for a picture that is not there.
+func unavailable() []byte {
+	const width, height = 200, 150
+	m := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(m, m.Bounds(), image.NewUniform(color.Gray{0xdd}), image.ZP, draw.Src)
+	line := color.Gray{0x99}
+	for x := 0; x < width; x++ {
+		y := x * height / width
+		for t := -1; t <= 1; t++ {
+			m.Set(x, y+t, line)
+			m.Set(x, height-1-y+t, line)
+		}
+	}
+	var buf bytes.Buffer
+	check(jpeg.Encode(&buf, m, nil))
+	return buf.Bytes()
+}
@@ -0,0 +1,29 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPlaceholder checks that the placeholder is a 404 carrying an image
+// that decodes.
+func TestPlaceholder(t *testing.T) {
+	w := httptest.NewRecorder()
+	servePlaceholder(w)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if ct := w.Header().Get("Content-type"); ct != "image/jpeg" {
+		t.Errorf("content type %q, want image/jpeg", ct)
+	}
+	m, _, err := image.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Bounds(), image.Rect(0, 0, 200, 150); got != want {
+		t.Errorf("placeholder is %v, want %v", got, want)
+	}
+}
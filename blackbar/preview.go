@@ -0,0 +1,84 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+
+	"appengine"
+	"censor"
+	"resize"
+)
+
+// previewThumbSize is the width of the thumbnail preview includes.
+const previewThumbSize = 200
+
+// preview is the HTTP handler for trying out output settings; it
+// handles "/preview". It renders the image as img would, scaled down to
+// width w if given, encodes it with the fmt and q form values, and
+// reports the result's format, dimensions and size in bytes, along with
+// a small JPEG thumbnail as a data URI. Nothing is stored.
+func preview(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	im, _, err := fetch(c, r.FormValue("id"))
+	check(err)
+	m, _, err := image.Decode(bytes.NewReader(im.Data))
+	check(err)
+	var dst image.Image
+	if dst, err = render(r, im, m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s := r.FormValue("w"); s != "" {
+		width, err := strconv.Atoi(s)
+		if err != nil || width < 1 {
+			http.Error(w, "bad width "+s, http.StatusBadRequest)
+			return
+		}
+		b := dst.Bounds()
+		if width < b.Dx() { // never enlarge
+			tw, th := censor.FitWithin(b, width, b.Dy())
+			dst = resize.Resize(dst, b, tw, th)
+		}
+	}
+	res, _, err := makePreview(dst, outputOf(r))
+	check(err)
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
+}
+
+// previewReport is what preview reports.
+type previewReport struct {
+	Type      string `json:"type"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Bytes     int    `json:"bytes"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+// makePreview returns the report preview gives on m encoded as o says,
+// and the encoded image it describes.
+func makePreview(m image.Image, o output) (previewReport, []byte, error) {
+	var buf bytes.Buffer
+	ctype, err := o.encode(&buf, m)
+	if err != nil {
+		return previewReport{}, nil, err
+	}
+
+	var thumb bytes.Buffer
+	t := m
+	if m.Bounds().Dx() > previewThumbSize {
+		t = thumbnail(m, previewThumbSize)
+	}
+	if err := jpeg.Encode(&thumb, t, nil); err != nil {
+		return previewReport{}, nil, err
+	}
+
+	b := m.Bounds()
+	res := previewReport{ctype, b.Dx(), b.Dy(), buf.Len(), "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(thumb.Bytes())}
+	return res, buf.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// TestMakePreview checks that the report preview gives matches the
+// image it encodes, in each format.
+func TestMakePreview(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			m.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0x80, 0xff})
+		}
+	}
+	for _, o := range []output{{}, {Format: "jpeg", Quality: 10}, {Format: "jpeg", Quality: 95}, {Format: "png"}} {
+		res, data, err := makePreview(m, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Bytes != len(data) {
+			t.Errorf("%+v: reports %d bytes, encoded %d", o, res.Bytes, len(data))
+		}
+		c, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%+v: %v", o, err)
+			continue
+		}
+		if res.Type != "image/"+format || res.Width != c.Width || res.Height != c.Height {
+			t.Errorf("%+v: reports %s %dx%d, encoded %s %dx%d", o, res.Type, res.Width, res.Height, format, c.Width, c.Height)
+		}
+		const prefix = "data:image/jpeg;base64,"
+		if !strings.HasPrefix(res.Thumbnail, prefix) {
+			t.Fatalf("%+v: thumbnail is not a JPEG data URI", o)
+		}
+		thumb, _ := base64.StdEncoding.DecodeString(res.Thumbnail[len(prefix):])
+		if c, _, err := image.DecodeConfig(bytes.NewReader(thumb)); err != nil || c.Width != previewThumbSize {
+			t.Errorf("%+v: thumbnail %dx%d (%v), want %d wide", o, c.Width, c.Height, err, previewThumbSize)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package blackbar
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/memcache"
+)
+
+// rateLimited wraps fn, which does expensive work for POST requests, so
+// that each client may make at most UploadRateLimit of them in every
+// UploadRateWindow. Clients over the limit get 429 Too Many Requests,
+// with a Retry-After header saying when the window ends, and the error
+// page, or, under /api/, the API's JSON error. Uploads through the API
+// and the pages count towards the same limit.
+//
+// Counts are kept in memcache, so they are shared by every instance. If
+// memcache fails, requests are let through rather than refused.
+func rateLimited(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || UploadRateLimit <= 0 {
+			fn(w, r)
+			return
+		}
+		c := appengine.NewContext(r)
+		now := time.Now()
+		window := now.Truncate(UploadRateWindow)
+		key := fmt.Sprintf("ratelimit:%s:%d", clientIP(r), window.Unix())
+		// Add gives the counter its expiry; it fails, harmlessly, if
+		// an earlier request in the window already made it.
+		memcache.Add(c, &memcache.Item{Key: key, Value: []byte("0"), Expiration: UploadRateWindow})
+		n, err := memcache.Increment(c, key, 1, 0)
+		if err != nil {
+			c.Warningf("rate limit: %v", err)
+			fn(w, r)
+			return
+		}
+		if n > uint64(UploadRateLimit) {
+			retry := window.Add(UploadRateWindow).Sub(now)/time.Second + 1
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry)))
+			msg := fmt.Sprintf("Too many uploads; please try again in %d seconds.", retry)
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				apiError(w, http.StatusTooManyRequests, msg)
+			} else {
+				errorPage(w, http.StatusTooManyRequests, msg)
+			}
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// clientIP returns the address of the client making r: the one App
+// Engine reports, or else the remote address of the connection.
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("X-AppEngine-User-IP"); ip != "" {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
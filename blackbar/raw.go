@@ -0,0 +1,33 @@
+package blackbar
+
+import (
+	"fmt"
+	"net/http"
+
+	"appengine"
+)
+
+// rawExts are the file name extensions of the types images are stored
+// as.
+var rawExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// raw is the HTTP handler for downloading an image as uploaded; it
+// handles "/raw". It serves the image stored under id before any of
+// its saved edits, byte for byte, as an attachment. Only DefaultBars,
+// which are painted on upload, are there.
+func raw(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	im, key, err := fetch(c, r.FormValue("id"))
+	check(err)
+	data := im.Data
+	if im.Original != nil {
+		data = im.Original
+	}
+	w.Header().Set("Content-type", im.contentType())
+	w.Header().Set("Content-disposition", fmt.Sprintf("attachment; filename=%q", key.StringID()+rawExts[im.contentType()]))
+	w.Write(data)
+}
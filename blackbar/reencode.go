@@ -0,0 +1,615 @@
+package blackbar
+
+// Partial re-encoding.
+//
+// Saving a bar used to decode the stored JPEG, paint on it and encode the
+// whole picture again, so every save cost the untouched parts of the
+// image another generation of compression loss. reencode works on the
+// entropy-coded data instead: it decodes the quantized DCT coefficients
+// of every block, recomputes only the MCUs (the 16x16 pixel units of a
+// 4:2:0 JPEG) in which the edited image differs from the stored one, and
+// writes every other block back exactly as it was.
+//
+// Only the kind of JPEG that image/jpeg writes, and so the kind upload
+// stores, is handled: baseline, three components, a single interleaved
+// scan and no restart markers. Anything else makes reencode report
+// failure, and the caller falls back to encoding the whole image.
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+)
+
+// reencode returns the JPEG data with the MCUs in which dst differs from
+// orig, the decoded form of data, re-encoded from dst. ok is false if
+// data is not a JPEG reencode can handle.
+func reencode(data []byte, orig image.Image, dst *image.RGBA) (out []byte, ok bool) {
+	f, ok := parseJPEG(data)
+	if !ok || orig.Bounds() != dst.Bounds() || dst.Bounds() != image.Rect(0, 0, f.width, f.height) {
+		return nil, false
+	}
+	if !f.decode(data[f.scanStart:f.scanEnd]) {
+		return nil, false
+	}
+
+	// Find the MCUs that contain edited pixels and recompute them.
+	mcuW, mcuH := 8*f.hmax, 8*f.vmax
+	changed := make([]bool, f.mcusX*f.mcusY)
+	for y := 0; y < f.height; y++ {
+		for x := 0; x < f.width; x++ {
+			r, g, b, a := orig.At(x, y).RGBA()
+			c := dst.RGBAAt(x, y)
+			if uint8(r>>8) != c.R || uint8(g>>8) != c.G || uint8(b>>8) != c.B || uint8(a>>8) != c.A {
+				changed[(y/mcuH)*f.mcusX+x/mcuW] = true
+			}
+		}
+	}
+	for i, c := range changed {
+		if c {
+			f.recode(dst, i%f.mcusX, i/f.mcusX)
+		}
+	}
+
+	scan, ok := f.encode()
+	if !ok {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:f.scanStart])
+	buf.Write(scan)
+	buf.Write(data[f.scanEnd:])
+	return buf.Bytes(), true
+}
+
+// jpegFile holds what reencode needs of a parsed JPEG.
+type jpegFile struct {
+	width, height int
+	hmax, vmax    int
+	mcusX, mcusY  int
+	comps         []jpegComp
+	quant         [4][64]int32 // in zig-zag order
+	dc, ac        [4]*huffTable
+
+	// data[scanStart:scanEnd] is the entropy-coded scan.
+	scanStart, scanEnd int
+}
+
+// jpegComp is one color component and its quantized coefficients.
+type jpegComp struct {
+	id     byte
+	h, v   int // sampling factors
+	tq     int // quantization table
+	td, ta int // DC and AC Huffman tables
+	bw     int // width of the component, in blocks
+	blocks [][64]int32
+}
+
+// parseJPEG reads the headers of data, up to and including the start of
+// its scan.
+func parseJPEG(data []byte) (*jpegFile, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, false
+	}
+	f := new(jpegFile)
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, false
+		}
+		marker := data[i+1]
+		if marker == 0xff { // fill byte
+			i++
+			continue
+		}
+		n := int(data[i+2])<<8 | int(data[i+3])
+		if n < 2 || i+2+n > len(data) {
+			return nil, false
+		}
+		seg := data[i+4 : i+2+n]
+		i += 2 + n
+		switch {
+		case marker == 0xdb:
+			if !f.parseDQT(seg) {
+				return nil, false
+			}
+		case marker == 0xc4:
+			if !f.parseDHT(seg) {
+				return nil, false
+			}
+		case marker == 0xc0:
+			if !f.parseSOF(seg) {
+				return nil, false
+			}
+		case marker > 0xc0 && marker <= 0xcf && marker != 0xc8:
+			return nil, false // progressive, lossless or arithmetic coded
+		case marker == 0xdd:
+			if len(seg) < 2 || seg[0] != 0 || seg[1] != 0 {
+				return nil, false // restart intervals
+			}
+		case marker == 0xda:
+			if !f.parseSOS(seg) {
+				return nil, false
+			}
+			// The scan runs to the next marker, which must be the end
+			// of the image: a second scan is beyond us.
+			f.scanStart = i
+			for ; i+1 < len(data); i++ {
+				if data[i] == 0xff && data[i+1] != 0 {
+					break
+				}
+			}
+			if i+1 >= len(data) || data[i+1] != 0xd9 {
+				return nil, false
+			}
+			f.scanEnd = i
+			return f, true
+		}
+	}
+}
+
+func (f *jpegFile) parseDQT(seg []byte) bool {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&15
+		if tq > 3 {
+			return false
+		}
+		seg = seg[1:]
+		switch {
+		case pq == 0 && len(seg) >= 64:
+			for k := range f.quant[tq] {
+				f.quant[tq][k] = int32(seg[k])
+			}
+			seg = seg[64:]
+		case pq == 1 && len(seg) >= 128:
+			for k := range f.quant[tq] {
+				f.quant[tq][k] = int32(seg[2*k])<<8 | int32(seg[2*k+1])
+			}
+			seg = seg[128:]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (f *jpegFile) parseDHT(seg []byte) bool {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return false
+		}
+		tc, th := seg[0]>>4, seg[0]&15
+		if tc > 1 || th > 3 {
+			return false
+		}
+		var counts [16]byte
+		copy(counts[:], seg[1:17])
+		total := 0
+		for _, c := range counts {
+			total += int(c)
+		}
+		if total > 256 || len(seg) < 17+total {
+			return false
+		}
+		t := newHuffTable(counts, seg[17:17+total])
+		if tc == 0 {
+			f.dc[th] = t
+		} else {
+			f.ac[th] = t
+		}
+		seg = seg[17+total:]
+	}
+	return true
+}
+
+func (f *jpegFile) parseSOF(seg []byte) bool {
+	if len(seg) < 6 || seg[0] != 8 || f.comps != nil {
+		return false
+	}
+	f.height = int(seg[1])<<8 | int(seg[2])
+	f.width = int(seg[3])<<8 | int(seg[4])
+	if f.width == 0 || f.height == 0 || seg[5] != 3 || len(seg) < 6+3*3 {
+		return false
+	}
+	for i := 0; i < 3; i++ {
+		c := seg[6+3*i:]
+		comp := jpegComp{id: c[0], h: int(c[1] >> 4), v: int(c[1] & 15), tq: int(c[2])}
+		if comp.h < 1 || comp.h > 2 || comp.v < 1 || comp.v > 2 || comp.tq > 3 {
+			return false
+		}
+		if comp.h > f.hmax {
+			f.hmax = comp.h
+		}
+		if comp.v > f.vmax {
+			f.vmax = comp.v
+		}
+		f.comps = append(f.comps, comp)
+	}
+	f.mcusX = (f.width + 8*f.hmax - 1) / (8 * f.hmax)
+	f.mcusY = (f.height + 8*f.vmax - 1) / (8 * f.vmax)
+	for i := range f.comps {
+		c := &f.comps[i]
+		if f.hmax%c.h != 0 || f.vmax%c.v != 0 {
+			return false
+		}
+		c.bw = f.mcusX * c.h
+		c.blocks = make([][64]int32, c.bw*f.mcusY*c.v)
+	}
+	return true
+}
+
+func (f *jpegFile) parseSOS(seg []byte) bool {
+	if f.comps == nil || len(seg) < 1 || int(seg[0]) != len(f.comps) || len(seg) < 1+2*len(f.comps)+3 {
+		return false
+	}
+	for i := range f.comps {
+		c := &f.comps[i]
+		if seg[1+2*i] != c.id {
+			return false
+		}
+		c.td, c.ta = int(seg[2+2*i]>>4), int(seg[2+2*i]&15)
+		if c.td > 3 || c.ta > 3 || f.dc[c.td] == nil || f.ac[c.ta] == nil || f.quant[c.tq][0] == 0 {
+			return false
+		}
+	}
+	s := seg[1+2*len(f.comps):]
+	return s[0] == 0 && s[1] == 63 && s[2] == 0 // baseline spectral selection
+}
+
+// decode reads the coefficients of every block from the scan.
+func (f *jpegFile) decode(scan []byte) bool {
+	r := &bitReader{data: scan}
+	preds := make([]int32, len(f.comps))
+	return f.eachBlock(func(ci int, blk *[64]int32) bool {
+		c := &f.comps[ci]
+		return r.block(blk, &preds[ci], f.dc[c.td], f.ac[c.ta])
+	})
+}
+
+// encode writes the coefficients of every block as a scan.
+func (f *jpegFile) encode() ([]byte, bool) {
+	w := new(bitWriter)
+	preds := make([]int32, len(f.comps))
+	if !f.eachBlock(func(ci int, blk *[64]int32) bool {
+		c := &f.comps[ci]
+		return w.block(blk, &preds[ci], f.dc[c.td], f.ac[c.ta])
+	}) {
+		return nil, false
+	}
+	w.flush()
+	return w.buf.Bytes(), true
+}
+
+// eachBlock calls fn for every block, in scan order, stopping if fn
+// returns false.
+func (f *jpegFile) eachBlock(fn func(ci int, blk *[64]int32) bool) bool {
+	for my := 0; my < f.mcusY; my++ {
+		for mx := 0; mx < f.mcusX; mx++ {
+			for ci := range f.comps {
+				c := &f.comps[ci]
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						if !fn(ci, &c.blocks[(my*c.v+v)*c.bw+mx*c.h+h]) {
+							return false
+						}
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// recode recomputes the blocks of the MCU at (mx, my) from the pixels of
+// m, the way image/jpeg would: convert to YCbCr, average chroma over the
+// subsampled pixels, transform and quantize.
+func (f *jpegFile) recode(m *image.RGBA, mx, my int) {
+	for ci := range f.comps {
+		c := &f.comps[ci]
+		sx, sy := f.hmax/c.h, f.vmax/c.v
+		for v := 0; v < c.v; v++ {
+			for h := 0; h < c.h; h++ {
+				bx, by := mx*c.h+h, my*c.v+v
+				var s [64]float64
+				for py := 0; py < 8; py++ {
+					for px := 0; px < 8; px++ {
+						sum := 0
+						for dy := 0; dy < sy; dy++ {
+							for dx := 0; dx < sx; dx++ {
+								sum += int(f.sample(m, ci, ((bx*8+px)*sx)+dx, ((by*8+py)*sy)+dy))
+							}
+						}
+						n := sx * sy
+						s[py*8+px] = float64((sum+n/2)/n) - 128
+					}
+				}
+				fdct(&s)
+				blk := &c.blocks[by*c.bw+bx]
+				for k := range blk {
+					blk[k] = int32(math.Floor(s[unzig[k]]/float64(f.quant[c.tq][k]) + 0.5))
+				}
+			}
+		}
+	}
+}
+
+// sample returns component ci (Y, Cb or Cr) of the pixel of m at (x, y),
+// extending the image's edge pixels beyond its bounds.
+func (f *jpegFile) sample(m *image.RGBA, ci, x, y int) uint8 {
+	if x >= f.width {
+		x = f.width - 1
+	}
+	if y >= f.height {
+		y = f.height - 1
+	}
+	p := m.Pix[m.PixOffset(x, y):]
+	yy, cb, cr := color.RGBToYCbCr(p[0], p[1], p[2])
+	switch ci {
+	case 0:
+		return yy
+	case 1:
+		return cb
+	}
+	return cr
+}
+
+// fdct computes the two-dimensional forward DCT of the 8x8 block s, in
+// place and in natural (row-major) order.
+func fdct(s *[64]float64) {
+	var t [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < 8; x++ {
+				sum += s[y*8+x] * dctCos[x][u]
+			}
+			t[y*8+u] = sum
+		}
+	}
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < 8; y++ {
+				sum += t[y*8+u] * dctCos[y][v]
+			}
+			s[v*8+u] = sum
+		}
+	}
+}
+
+// dctCos[x][u] is C(u)/2 * cos((2x+1)uπ/16), the DCT basis with its
+// normalization folded in.
+var dctCos [8][8]float64
+
+func init() {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			c := 0.5
+			if u == 0 {
+				c = 0.5 / math.Sqrt2
+			}
+			dctCos[x][u] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+}
+
+// unzig maps the zig-zag order of coefficients to natural order.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// huffTable is a Huffman table, set up for both decoding and encoding.
+type huffTable struct {
+	maxCode [17]int32 // largest code of each length, or -1
+	minCode [17]int32 // smallest code of each length
+	valPtr  [17]int32 // index in vals of the value for minCode
+	vals    []byte
+
+	code [256]uint16 // code for each value
+	size [256]uint8  // length of code for each value, or 0 if none
+}
+
+func newHuffTable(counts [16]byte, vals []byte) *huffTable {
+	t := &huffTable{vals: vals}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		t.maxCode[l] = -1
+		if n > 0 {
+			t.minCode[l], t.valPtr[l] = code, k
+			for i := int32(0); i < n; i++ {
+				t.code[vals[k+i]] = uint16(code + i)
+				t.size[vals[k+i]] = uint8(l)
+			}
+			code += n
+			k += n
+			t.maxCode[l] = code - 1
+		}
+		code <<= 1
+	}
+	return t
+}
+
+// bitReader reads the bits of an entropy-coded scan.
+type bitReader struct {
+	data []byte
+	acc  byte
+	n    uint // unread bits in acc
+}
+
+func (r *bitReader) bit() (int32, bool) {
+	if r.n == 0 {
+		if len(r.data) == 0 {
+			return 0, false
+		}
+		r.acc = r.data[0]
+		r.data = r.data[1:]
+		if r.acc == 0xff { // byte stuffing
+			if len(r.data) == 0 || r.data[0] != 0 {
+				return 0, false
+			}
+			r.data = r.data[1:]
+		}
+		r.n = 8
+	}
+	r.n--
+	return int32(r.acc>>r.n) & 1, true
+}
+
+// receive reads an s-bit magnitude and extends its sign.
+func (r *bitReader) receive(s uint8) (int32, bool) {
+	v := int32(0)
+	for i := uint8(0); i < s; i++ {
+		b, ok := r.bit()
+		if !ok {
+			return 0, false
+		}
+		v = v<<1 | b
+	}
+	if s > 0 && v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v, true
+}
+
+func (r *bitReader) decode(t *huffTable) (uint8, bool) {
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		b, ok := r.bit()
+		if !ok {
+			return 0, false
+		}
+		code = code<<1 | b
+		if code <= t.maxCode[l] {
+			return t.vals[t.valPtr[l]+code-t.minCode[l]], true
+		}
+	}
+	return 0, false
+}
+
+// block reads one block's coefficients, in zig-zag order, updating the
+// DC prediction.
+func (r *bitReader) block(blk *[64]int32, pred *int32, dc, ac *huffTable) bool {
+	s, ok := r.decode(dc)
+	if !ok {
+		return false
+	}
+	diff, ok := r.receive(s)
+	if !ok {
+		return false
+	}
+	*pred += diff
+	blk[0] = *pred
+	for k := 1; k < 64; k++ {
+		rs, ok := r.decode(ac)
+		if !ok {
+			return false
+		}
+		run, s := int(rs>>4), rs&15
+		if s == 0 {
+			if run != 15 {
+				break // end of block
+			}
+			k += 15
+			continue
+		}
+		k += run
+		if k > 63 {
+			return false
+		}
+		if blk[k], ok = r.receive(s); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// bitWriter writes the bits of an entropy-coded scan.
+type bitWriter struct {
+	buf bytes.Buffer
+	acc byte
+	n   uint // bits in acc
+}
+
+func (w *bitWriter) emit(bits uint32, n uint8) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.acc = w.acc<<1 | byte(bits>>uint(i)&1)
+		w.n++
+		if w.n == 8 {
+			w.buf.WriteByte(w.acc)
+			if w.acc == 0xff {
+				w.buf.WriteByte(0) // byte stuffing
+			}
+			w.acc, w.n = 0, 0
+		}
+	}
+}
+
+// flush pads the last byte with 1 bits.
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.emit(0x7f, uint8(8-w.n))
+	}
+}
+
+// huff writes the code for v, reporting false if t has none.
+func (w *bitWriter) huff(t *huffTable, v uint8) bool {
+	if t.size[v] == 0 {
+		return false
+	}
+	w.emit(uint32(t.code[v]), t.size[v])
+	return true
+}
+
+// block writes one block's coefficients, updating the DC prediction.
+func (w *bitWriter) block(blk *[64]int32, pred *int32, dc, ac *huffTable) bool {
+	s, bits := category(blk[0] - *pred)
+	*pred = blk[0]
+	if !w.huff(dc, s) {
+		return false
+	}
+	w.emit(bits, s)
+	run := uint8(0)
+	for k := 1; k < 64; k++ {
+		if blk[k] == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			if !w.huff(ac, 0xf0) {
+				return false
+			}
+		}
+		s, bits := category(blk[k])
+		if s > 15 || !w.huff(ac, run<<4|s) {
+			return false
+		}
+		w.emit(bits, s)
+		run = 0
+	}
+	if run > 0 {
+		return w.huff(ac, 0x00)
+	}
+	return true
+}
+
+// category returns the magnitude category of v and the bits that encode
+// it within that category.
+func category(v int32) (uint8, uint32) {
+	a := v
+	if a < 0 {
+		a, v = -a, v-1
+	}
+	s := uint8(0)
+	for ; a > 0; a >>= 1 {
+		s++
+	}
+	return s, uint32(v) & (1<<s - 1)
+}
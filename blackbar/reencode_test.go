@@ -0,0 +1,94 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"censor"
+)
+
+// texturedJPEG returns a w by h JPEG with plenty of detail to lose, and
+// the image it decodes to.
+func texturedJPEG(t *testing.T, w, h int) ([]byte, image.Image) {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetRGBA(x, y, color.RGBA{uint8(x * 7), uint8(y * 5), uint8(x * y), 0xff})
+		}
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, m, &jpeg.Options{Quality: 75}); err != nil {
+		t.Fatal(err)
+	}
+	orig, err := jpeg.Decode(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes(), orig
+}
+
+// TestReencodeUnchanged checks that, with nothing edited, reencode
+// gives back the very bytes it was given.
+func TestReencodeUnchanged(t *testing.T) {
+	data, orig := texturedJPEG(t, 64, 48)
+	out, ok := reencode(data, orig, censor.RGBA(orig))
+	if !ok {
+		t.Fatal("reencode failed on a JPEG from image/jpeg")
+	}
+	if !bytes.Equal(out, data) {
+		t.Error("reencode changed an unedited JPEG")
+	}
+}
+
+// TestReencodeFidelity checks that reencode leaves the pixels outside
+// the edited MCUs exactly as they were, where encoding the whole image
+// again does not, and that the edit is there.
+func TestReencodeFidelity(t *testing.T) {
+	data, orig := texturedJPEG(t, 128, 96)
+	dst := image.NewRGBA(orig.Bounds())
+	copy(dst.Pix, censor.RGBA(orig).Pix)
+	edit := image.Rect(40, 40, 56, 50) // within the MCUs from (32, 32) to (64, 64)
+	censor.PaintRect(dst, edit, image.NewUniform(color.Black), censor.Solid)
+
+	out, ok := reencode(data, orig, dst)
+	if !ok {
+		t.Fatal("reencode failed on a JPEG from image/jpeg")
+	}
+	partial, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, dst, &jpeg.Options{Quality: 75}); err != nil {
+		t.Fatal(err)
+	}
+	full, err := jpeg.Decode(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	touched := image.Rect(32, 32, 64, 64)
+	fullDiffers := false
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 128; x++ {
+			if image.Pt(x, y).In(touched) {
+				continue
+			}
+			if partial.At(x, y) != orig.At(x, y) {
+				t.Fatalf("pixel (%d, %d) outside the edit changed", x, y)
+			}
+			if full.At(x, y) != orig.At(x, y) {
+				fullDiffers = true
+			}
+		}
+	}
+	if !fullDiffers {
+		t.Error("encoding the whole image again lost nothing either, so the test shows nothing")
+	}
+	if r, _, _, _ := partial.At(48, 45).RGBA(); r>>8 > 0x20 {
+		t.Errorf("edited pixel (48, 45) has red %#x, want near black", r>>8)
+	}
+}
@@ -0,0 +1,45 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+)
+
+// maxRenditions bounds the number of formats one request may ask for.
+const maxRenditions = 4
+
+// serveRenditions answers an img request for several formats at once,
+// as in fmt=jpeg,avif, by encoding m in each and serving a JSON list of
+// the results, base64 encoded. A format this build cannot write comes
+// back as JPEG, and its entry's type says so.
+func serveRenditions(w http.ResponseWriter, r *http.Request, m image.Image, formats []string) {
+	if len(formats) > maxRenditions {
+		http.Error(w, fmt.Sprintf("%d formats requested, more than the limit of %d", len(formats), maxRenditions), http.StatusBadRequest)
+		return
+	}
+	type rendition struct {
+		Format string `json:"format"`
+		Type   string `json:"type"`
+		Data   string `json:"data"`
+	}
+	var res []rendition
+	o := outputOf(r)
+	for _, f := range formats {
+		o.Format = f
+		var buf bytes.Buffer
+		ctype, err := o.encode(&buf, m)
+		check(err)
+		data := buf.Bytes()
+		if ctype == "image/jpeg" && r.FormValue("icc") == "srgb" {
+			data, err = embedICC(data, srgb())
+			check(err)
+		}
+		res = append(res, rendition{f, ctype, base64.StdEncoding.EncodeToString(data)})
+	}
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
+}
@@ -0,0 +1,52 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestServeRenditions checks that a request for two formats gets both,
+// each decoding to the image, and that too many formats are refused.
+func TestServeRenditions(t *testing.T) {
+	m := solidImage(40, 30, color.RGBA{0xff, 0, 0, 0xff})
+	w := httptest.NewRecorder()
+	serveRenditions(w, httptest.NewRequest("GET", "/img?id=x&fmt=jpeg,png", nil), m, []string{"jpeg", "png"})
+	var res []struct{ Format, Type, Data string }
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("got %d renditions, want 2", len(res))
+	}
+	for i, want := range []string{"jpeg", "png"} {
+		if res[i].Format != want || res[i].Type != "image/"+want {
+			t.Errorf("rendition %d is %s, %s, want %s", i, res[i].Format, res[i].Type, want)
+		}
+		data, err := base64.StdEncoding.DecodeString(res[i].Data)
+		if err != nil {
+			t.Errorf("%s: %v", want, err)
+			continue
+		}
+		got, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil || format != want {
+			t.Errorf("%s: decoded as %q: %v", want, format, err)
+			continue
+		}
+		if got.Bounds() != m.Bounds() {
+			t.Errorf("%s: decoded %v, want %v", want, got.Bounds(), m.Bounds())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	formats := make([]string, maxRenditions+1)
+	serveRenditions(w, httptest.NewRequest("GET", "/img", nil), m, formats)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("%d formats: got %d, want %d", len(formats), w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,107 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"net/http"
+
+	"appengine"
+	"censor"
+	"resize"
+)
+
+// Bounds on the animations served by reveal.
+const (
+	revealFrames  = 8
+	revealMaxSize = 400 // pixels, in either dimension
+	revealDelay   = 10  // per frame, in hundredths of a second
+	revealHold    = 200 // on the last frame
+)
+
+// reveal is the HTTP handler for animating a bar; it handles "/reveal".
+// It serves an animated GIF that starts with the clean image and slides
+// the bar given by x, y and s in from the left.
+func reveal(w http.ResponseWriter, r *http.Request) {
+	b, err := barOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if b.X <= 0 {
+		http.Error(w, "reveal needs a bar position", http.StatusBadRequest)
+		return
+	}
+	c := appengine.NewContext(r)
+	im, _, err := fetch(c, r.FormValue("id"))
+	check(err)
+	anim, err := revealAnimation(r, im, b)
+	check(err)
+
+	w.Header().Set("Content-type", "image/gif")
+	check(gif.EncodeAll(w, anim))
+}
+
+// revealAnimation returns the animation reveal serves for the bar b
+// on im. It starts from the image as uploaded, since saved edits may
+// have censored what the bar is meant to cover.
+func revealAnimation(r *http.Request, im *Image, b censor.Bar) (*gif.GIF, error) {
+	data := im.Data
+	if im.Original != nil {
+		data = im.Original
+	}
+	m, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	fill, err := fillOf(r, m)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	// Scale large images down, and the bar with them.
+	target := b.Rect()
+	if b := m.Bounds(); b.Dx() > revealMaxSize || b.Dy() > revealMaxSize {
+		w, h := censor.FitWithin(b, revealMaxSize, revealMaxSize)
+		m = resize.Resize(m, b, w, h)
+		target = image.Rect(
+			target.Min.X*w/b.Dx(), target.Min.Y*h/b.Dy(),
+			target.Max.X*w/b.Dx(), target.Max.Y*h/b.Dy())
+	}
+	base := censor.RGBA(m)
+
+	anim := &gif.GIF{
+		Image: make([]*image.Paletted, revealFrames),
+		Delay: make([]int, revealFrames),
+	}
+	// Dithering dominates the cost, and frames are independent, so
+	// they are drawn by a few workers. Each writes only its own slot,
+	// which keeps the frames in order.
+	eachFrame(revealFrames, func(i int) {
+		anim.Image[i] = revealFrame(base, fill, target, i)
+	})
+	for i := range anim.Delay {
+		anim.Delay[i] = revealDelay
+	}
+	anim.Delay[revealFrames-1] = revealHold
+	return anim, nil
+}
+
+// revealFrame returns frame i of the animation reveal makes of base,
+// with the bar at target painted from fill. base and fill are only
+// read, so frames may be drawn concurrently.
+func revealFrame(base *image.RGBA, fill image.Image, target image.Rectangle, i int) *image.Paletted {
+	// The bar starts just off the left edge and ends in place.
+	dx := target.Max.X * (revealFrames - 1 - i) / (revealFrames - 1)
+	frame := image.NewRGBA(base.Bounds())
+	draw.Draw(frame, frame.Bounds(), base, frame.Bounds().Min, draw.Src)
+	br := target.Sub(image.Pt(dx, 0))
+	// Over, as img paints bars, so a translucent fill blends in
+	// rather than punching a hole in the picture.
+	draw.Draw(frame, br, fill, br.Min, draw.Over)
+	p := image.NewPaletted(frame.Bounds(), palette.Plan9)
+	draw.FloydSteinberg.Draw(p, p.Bounds(), frame, frame.Bounds().Min)
+	return p
+}
@@ -0,0 +1,109 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"testing"
+
+	"censor"
+)
+
+// encodePNG returns m encoded as PNG.
+func encodePNG(t *testing.T, m image.Image) []byte {
+	var b bytes.Buffer
+	if err := png.Encode(&b, m); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// TestRevealAnimation checks that reveal animates from the image as
+// uploaded, not as saved, and ends with the bar in place.
+func TestRevealAnimation(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	black := color.RGBA{0, 0, 0, 0xff}
+	im := &Image{
+		Data:     encodePNG(t, solidImage(200, 100, black)),
+		Original: encodePNG(t, solidImage(200, 100, white)),
+	}
+	b := censor.Bar{X: 100, Y: 50, S: 0}
+	anim, err := revealAnimation(formRequest(url.Values{}), im, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != revealFrames || len(anim.Delay) != revealFrames {
+		t.Fatalf("got %d frames and %d delays, want %d", len(anim.Image), len(anim.Delay), revealFrames)
+	}
+	first, last := anim.Image[0], anim.Image[revealFrames-1]
+	for _, p := range []image.Point{{0, 0}, {100, 50}, {199, 99}} {
+		if got := color.RGBAModel.Convert(first.At(p.X, p.Y)); got != white {
+			t.Errorf("first frame: pixel %v is %v, want %v", p, got, white)
+		}
+	}
+	if got := color.RGBAModel.Convert(last.At(100, 50)); got != black {
+		t.Errorf("last frame: bar is %v, want %v", got, black)
+	}
+	if got := color.RGBAModel.Convert(last.At(100, 10)); got != white {
+		t.Errorf("last frame: pixel outside bar is %v, want %v", got, white)
+	}
+}
+
+// TestRevealTranslucent checks that a translucent bar dims what is
+// under it rather than replacing it.
+func TestRevealTranslucent(t *testing.T) {
+	im := &Image{Data: encodePNG(t, solidImage(200, 100, color.White))}
+	b := censor.Bar{X: 100, Y: 50, S: 0}
+	anim, err := revealAnimation(formRequest(url.Values{"c": {"00000080"}}), im, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Dithering may mix nearby grays, but none of them is black or
+	// white.
+	c := color.RGBAModel.Convert(anim.Image[revealFrames-1].At(100, 50)).(color.RGBA)
+	if c.R < 0x40 || c.R > 0xc0 {
+		t.Errorf("translucent bar is %v, want a mid gray", c)
+	}
+}
+
+// TestRevealScaled checks that reveal scales large images down to
+// revealMaxSize, and the bar with them.
+func TestRevealScaled(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	im := &Image{Data: encodePNG(t, solidImage(1000, 500, white))}
+	b := censor.Bar{X: 500, Y: 250, S: 3}
+	anim, err := revealAnimation(formRequest(url.Values{}), im, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := anim.Image[revealFrames-1]
+	if got, want := last.Bounds(), image.Rect(0, 0, revealMaxSize, revealMaxSize/2); got != want {
+		t.Fatalf("frames are %v, want %v", got, want)
+	}
+	if got := color.RGBAModel.Convert(last.At(200, 100)); got == white {
+		t.Error("bar not painted at the center of the scaled image")
+	}
+}
+
+// TestRevealTransparent checks that a wholly transparent bar leaves
+// every frame as the picture was.
+func TestRevealTransparent(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	im := &Image{Data: encodePNG(t, solidImage(200, 100, white))}
+	b := censor.Bar{X: 100, Y: 50, S: 0}
+	anim, err := revealAnimation(formRequest(url.Values{"c": {"00000000"}}), im, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range anim.Image {
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 200; x++ {
+				if got := color.RGBAModel.Convert(f.At(x, y)); got != white {
+					t.Fatalf("frame %d: pixel (%d, %d) is %v, want %v", i, x, y, got, white)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package blackbar
+
+import (
+	"context"
+	"net/http"
+)
+
+// Serve runs the handlers this package registers on http.DefaultServeMux
+// in a server of its own listening on addr, for running outside App
+// Engine. When ctx is canceled, the server stops accepting connections
+// and waits up to ShutdownTimeout for requests in progress to finish,
+// so an image being processed is not cut off. It returns nil after a
+// clean shutdown.
+func Serve(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: http.DefaultServeMux}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc: // failed to start
+		return err
+	case <-ctx.Done():
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(sctx)
+	if e := <-errc; e != http.ErrServerClosed && err == nil {
+		err = e
+	}
+	return err
+}
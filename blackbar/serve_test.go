@@ -0,0 +1,76 @@
+package blackbar
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// serveRuns numbers the runs of TestServeDrains, each of which needs a
+// path of its own on http.DefaultServeMux.
+var serveRuns int
+
+// TestServeDrains checks that a request in progress when Serve is told
+// to stop still gets its whole answer, and that Serve then returns nil.
+func TestServeDrains(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	serveRuns++
+	path := fmt.Sprintf("/test/slow/%d", serveRuns)
+	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte("done"))
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, addr) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	got := make(chan result, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ { // until the server is up
+			if resp, err = http.Get("http://" + addr + path); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		got <- result{string(b), err}
+	}()
+
+	select {
+	case <-started:
+	case r := <-got:
+		t.Fatalf("request failed before reaching the handler: %v", r.err)
+	}
+	cancel()
+	time.Sleep(50 * time.Millisecond) // let shutdown begin
+	close(release)
+	if r := <-got; r.err != nil || r.body != "done" {
+		t.Errorf("in-flight request got %q, %v; want done", r.body, r.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve returned %v, want nil", err)
+	}
+}
@@ -0,0 +1,137 @@
+package blackbar
+
+// Signed URLs. If SigningKey is set, handlers that serve or change a
+// stored image act only on requests whose form values carry an expiry
+// time, exp, in seconds since the epoch, and sig, an HMAC under the key
+// of exp and the other form values. Whoever minted the URL thus fixes
+// the image and the bars, and the URL stops working once it expires.
+//
+// A URL with scope=id is signed over the id alone and grants any use of
+// that image, such as editing it. Such URLs are minted only on the
+// server, for whoever uploaded the image: upload redirects to the editor
+// with one, and the editor renews it. Listings of images are turned off,
+// since they would name images to anyone.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// unsignedValues are the form values a signature does not cover: the
+// signature itself, the CSRF token sent with saves, and the version the
+// editor adds to defeat caching.
+var unsignedValues = []string{"sig", "exp", "csrf", "v"}
+
+// isUnsigned reports whether k is one of unsignedValues.
+func isUnsigned(k string) bool {
+	for _, u := range unsignedValues {
+		if k == u {
+			return true
+		}
+	}
+	return false
+}
+
+// signature returns the signature of the form values v, expiring at exp.
+func signature(v url.Values, exp int64) string {
+	signed := url.Values{}
+	for k, vs := range v {
+		signed[k] = vs
+	}
+	for _, k := range unsignedValues {
+		signed.Del(k)
+	}
+	mac := hmac.New(sha256.New, []byte(SigningKey))
+	mac.Write([]byte(signed.Encode() + "\n" + strconv.FormatInt(exp, 10)))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signQuery returns the query string of a URL with the form values v,
+// signed if SigningKey is set. It is good for SignedURLLifetime.
+func signQuery(v url.Values) string {
+	if SigningKey == "" {
+		return v.Encode()
+	}
+	exp := time.Now().Add(SignedURLLifetime).Unix()
+	q := url.Values{}
+	for k, vs := range v {
+		q[k] = vs
+	}
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("sig", signature(v, exp))
+	return q.Encode()
+}
+
+// signedID returns the query string naming image id, signed, if
+// SigningKey is set, for any use of it.
+func signedID(id string) string {
+	v := url.Values{"id": {id}}
+	if SigningKey != "" {
+		v.Set("scope", "id")
+	}
+	return signQuery(v)
+}
+
+// editURL returns the URL of the editor for image id, signed if need be.
+func editURL(id string) string {
+	return "/edit?" + signedID(id)
+}
+
+// checkSignature reports whether r carries a valid signature that has
+// not expired, replying with 403 Forbidden if not. It always succeeds if
+// SigningKey is unset.
+func checkSignature(w http.ResponseWriter, r *http.Request) bool {
+	if SigningKey == "" {
+		return true
+	}
+	r.ParseForm()
+	v := r.Form
+	if r.FormValue("scope") == "id" {
+		// An overlay is another image, which the signature does
+		// not grant.
+		if r.FormValue("overlay") != "" {
+			http.Error(w, "an overlay needs a URL signed for it", http.StatusForbidden)
+			return false
+		}
+		// Nor does it grant the other images a sprite sheet lists.
+		if ids := r.FormValue("ids"); ids != "" && ids != r.FormValue("id") {
+			http.Error(w, "ids other than the signed id need a URL signed for them", http.StatusForbidden)
+			return false
+		}
+		v = url.Values{"id": {r.FormValue("id")}, "scope": {"id"}}
+	}
+	exp, err := strconv.ParseInt(r.FormValue("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > exp ||
+		!hmac.Equal([]byte(r.FormValue("sig")), []byte(signature(v, exp))) {
+		http.Error(w, "missing, invalid or expired signature", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// signed wraps the argument handler, which serves or changes the image
+// its request names, so that it runs only if checkSignature allows.
+func signed(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkSignature(w, r) {
+			fn(w, r)
+		}
+	}
+}
+
+// unlisted wraps the argument handler, which lists stored images, so
+// that it refuses to while URLs must be signed.
+func unlisted(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if SigningKey != "" {
+			http.Error(w, "images are not listed while URLs are signed", http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	}
+}
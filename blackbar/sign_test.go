@@ -0,0 +1,36 @@
+package blackbar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSignedIDScope checks that a URL signed for one image grants that
+// image alone, not the other images a sprite sheet or overlay names.
+func TestSignedIDScope(t *testing.T) {
+	defer func(k string) { SigningKey = k }(SigningKey)
+	SigningKey = "secret"
+	q := signedID("mine")
+	for _, tt := range []struct {
+		extra string
+		want  int
+	}{
+		{"", http.StatusOK},
+		{"&ids=mine", http.StatusOK},
+		{"&ids=mine,theirs", http.StatusForbidden},
+		{"&ids=theirs", http.StatusForbidden},
+		{"&overlay=theirs", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		signed(func(w http.ResponseWriter, r *http.Request) {})(w, httptest.NewRequest("GET", "/sprite?"+q+tt.extra, nil))
+		if w.Code != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.extra, w.Code, tt.want)
+		}
+	}
+	w := httptest.NewRecorder()
+	signed(sprite)(w, httptest.NewRequest("GET", "/sprite?"+q+"&ids=theirs", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("sprite of a foreign id: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
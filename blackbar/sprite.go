@@ -0,0 +1,115 @@
+package blackbar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"appengine"
+	"censor"
+	"resize"
+)
+
+// Bounds on the sheets served by sprite.
+const (
+	maxSpriteImages = 64
+	maxSpriteCell   = 400  // pixels
+	maxSpriteSize   = 4096 // pixels, in either dimension
+)
+
+// sprite is the HTTP handler for thumbnail sprite sheets; it handles
+// "/sprite". It packs thumbnails of the images listed in ids into a grid
+// cols cells wide (default 4) of cells cell pixels square (default 100),
+// each thumbnail centered in its cell, and serves JSON holding the sheet
+// as a JPEG data URI and, for each id, the rectangle its thumbnail
+// occupies, for use as a CSS background position.
+func sprite(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.FormValue("ids"), ",")
+	if r.FormValue("ids") == "" || len(ids) > maxSpriteImages {
+		http.Error(w, fmt.Sprintf("ids must list 1 to %d images", maxSpriteImages), http.StatusBadRequest)
+		return
+	}
+	get := func(n string, def, max int) (int, error) {
+		s := r.FormValue(n)
+		if s == "" {
+			return def, nil
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 1 || i > max {
+			return 0, fmt.Errorf("%s must be a number from 1 to %d", n, max)
+		}
+		return i, nil
+	}
+	cols, err := get("cols", 4, maxSpriteImages)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cell, err := get("cell", 100, maxSpriteCell)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cols > len(ids) {
+		cols = len(ids)
+	}
+	rows := (len(ids) + cols - 1) / cols
+	if cols*cell > maxSpriteSize || rows*cell > maxSpriteSize {
+		http.Error(w, fmt.Sprintf("sheet would be larger than %d pixels", maxSpriteSize), http.StatusBadRequest)
+		return
+	}
+
+	c := appengine.NewContext(r)
+	images := make([]image.Image, len(ids))
+	for i, id := range ids {
+		m, _, err := load(c, id)
+		check(err)
+		images[i] = m
+	}
+	sheet, cells := packSprite(ids, images, cols, cell)
+	var buf bytes.Buffer
+	check(jpeg.Encode(&buf, sheet, nil))
+
+	res := struct {
+		Image string                `json:"image"`
+		Cells map[string]spriteCell `json:"cells"`
+	}{"data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), cells}
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
+}
+
+// spriteCell is where a thumbnail lies in a sprite sheet.
+type spriteCell struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// packSprite returns the sheet sprite makes of images, those stored
+// under ids, in a grid cols cells wide of cells cell pixels square, and
+// where each id's thumbnail lies in it.
+func packSprite(ids []string, images []image.Image, cols, cell int) (*image.RGBA, map[string]spriteCell) {
+	rows := (len(ids) + cols - 1) / cols
+	cells := make(map[string]spriteCell)
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*cell, rows*cell))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	for i, m := range images {
+		b := m.Bounds()
+		tw, th := censor.FitWithin(b, cell, cell)
+		t := resize.Resize(m, b, tw, th)
+		at := image.Pt(i%cols*cell+(cell-tw)/2, i/cols*cell+(cell-th)/2)
+		dr := image.Rectangle{at, at.Add(image.Pt(tw, th))}
+		draw.Draw(sheet, dr, t, t.Bounds().Min, draw.Src)
+		cells[ids[i]] = spriteCell{dr.Min.X, dr.Min.Y, tw, th}
+	}
+	return sheet, cells
+}
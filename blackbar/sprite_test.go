@@ -0,0 +1,53 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestPackSprite checks that the map of cells matches where each
+// thumbnail was packed: in a grid, centered in its cell, in order.
+func TestPackSprite(t *testing.T) {
+	colors := []color.RGBA{
+		{0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}, {0, 0, 0xff, 0xff}, {0, 0, 0, 0xff}, {0xff, 0xff, 0, 0xff},
+	}
+	sizes := []image.Point{{200, 100}, {100, 200}, {50, 50}, {300, 300}, {1000, 10}}
+	ids := []string{"wide", "tall", "small", "square", "strip"}
+	images := make([]image.Image, len(ids))
+	for i := range ids {
+		images[i] = solidImage(sizes[i].X, sizes[i].Y, colors[i])
+	}
+	sheet, cells := packSprite(ids, images, 3, 100)
+	if got, want := sheet.Bounds(), image.Rect(0, 0, 300, 200); got != want {
+		t.Fatalf("sheet is %v, want %v", got, want)
+	}
+	want := map[string]spriteCell{
+		"wide":   {0, 25, 100, 50},
+		"tall":   {125, 0, 50, 100},
+		"small":  {200, 0, 100, 100},
+		"square": {0, 100, 100, 100},
+		"strip":  {100, 149, 100, 1},
+	}
+	for i, id := range ids {
+		c, ok := cells[id]
+		if !ok || c != want[id] {
+			t.Errorf("%s: cell %+v, want %+v", id, c, want[id])
+			continue
+		}
+		// The thumbnail fills its rectangle, and only that, within its
+		// cell.
+		for _, p := range []image.Point{{c.X, c.Y}, {c.X + c.W - 1, c.Y + c.H - 1}} {
+			if got := sheet.RGBAAt(p.X, p.Y); got != colors[i] {
+				t.Errorf("%s: pixel %v is %v, want %v", id, p, got, colors[i])
+			}
+		}
+		cell := image.Rect(i%3*100, i/3*100, i%3*100+100, i/3*100+100)
+		if !image.Rect(c.X, c.Y, c.X+c.W, c.Y+c.H).In(cell) {
+			t.Errorf("%s: %+v outside its cell %v", id, c, cell)
+		}
+	}
+	if got := sheet.RGBAAt(250, 150); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("empty cell is %v, want white", got)
+	}
+}
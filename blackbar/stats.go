@@ -0,0 +1,75 @@
+package blackbar
+
+import (
+	"encoding/json"
+	"image"
+	"math"
+	"net/http"
+
+	"appengine"
+)
+
+// statsSamples is roughly the most pixels stats looks at; larger images
+// are sampled on a regular grid.
+const statsSamples = 250000
+
+// channelStats is the mean and standard deviation of one channel, on a
+// 0-255 scale.
+type channelStats struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// stats is the HTTP handler for image statistics; it handles "/stats".
+// It reports, as JSON, the mean and standard deviation of each color
+// channel and of the luminance, which makes it easy to flag images that
+// are all black (over-redacted) or all white (blank).
+func stats(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	m, _, err := load(c, r.FormValue("id"))
+	check(err)
+
+	res := imageStats(m)
+	w.Header().Set("Content-type", "application/json")
+	check(json.NewEncoder(w).Encode(res))
+}
+
+// imageStatistics are the statistics served by stats.
+type imageStatistics struct {
+	Red       channelStats `json:"red"`
+	Green     channelStats `json:"green"`
+	Blue      channelStats `json:"blue"`
+	Luminance channelStats `json:"luminance"`
+	Samples   int          `json:"samples"` // number of pixels looked at
+}
+
+// imageStats computes the statistics of m.
+func imageStats(m image.Image) (res imageStatistics) {
+	b := m.Bounds()
+	step := 1
+	if n := b.Dx() * b.Dy(); n > statsSamples {
+		step = int(math.Ceil(math.Sqrt(float64(n) / statsSamples)))
+	}
+	var sum, sq [4]float64
+	n := 0
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r32, g32, b32, _ := m.At(x, y).RGBA()
+			r8, g8, b8 := uint8(r32>>8), uint8(g32>>8), uint8(b32>>8)
+			for i, v := range [4]uint8{r8, g8, b8, luminance(r8, g8, b8)} {
+				sum[i] += float64(v)
+				sq[i] += float64(v) * float64(v)
+			}
+			n++
+		}
+	}
+	res.Samples = n
+	if n == 0 {
+		return res
+	}
+	for i, s := range []*channelStats{&res.Red, &res.Green, &res.Blue, &res.Luminance} {
+		s.Mean = sum[i] / float64(n)
+		s.StdDev = math.Sqrt(math.Max(0, sq[i]/float64(n)-s.Mean*s.Mean))
+	}
+	return res
+}
@@ -0,0 +1,55 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// TestImageStats checks the statistics of an image whose left half is
+// black and right half pure red.
+func TestImageStats(t *testing.T) {
+	m := solidImage(100, 50, color.Black)
+	for y := 0; y < 50; y++ {
+		for x := 50; x < 100; x++ {
+			m.SetRGBA(x, y, color.RGBA{0xff, 0, 0, 0xff})
+		}
+	}
+	s := imageStats(m)
+	if s.Samples != 5000 {
+		t.Errorf("samples %d, want 5000", s.Samples)
+	}
+	lum := float64(luminance(0xff, 0, 0))
+	for _, tt := range []struct {
+		name string
+		got  channelStats
+		want channelStats
+	}{
+		{"red", s.Red, channelStats{127.5, 127.5}},
+		{"green", s.Green, channelStats{0, 0}},
+		{"blue", s.Blue, channelStats{0, 0}},
+		{"luminance", s.Luminance, channelStats{lum / 2, lum / 2}},
+	} {
+		if math.Abs(tt.got.Mean-tt.want.Mean) > 1e-9 || math.Abs(tt.got.StdDev-tt.want.StdDev) > 1e-9 {
+			t.Errorf("%s: %+v, want %+v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// TestImageStatsSampled checks that large images are sampled, and that
+// sampling a uniform image changes nothing.
+func TestImageStatsSampled(t *testing.T) {
+	gray := color.Gray{0x40}
+	m := image.NewGray(image.Rect(0, 0, 1000, 1000))
+	for i := range m.Pix {
+		m.Pix[i] = gray.Y
+	}
+	s := imageStats(m)
+	if s.Samples > statsSamples || s.Samples < statsSamples/4 {
+		t.Errorf("samples %d, want about %d", s.Samples, statsSamples)
+	}
+	if s.Luminance.Mean != 0x40 || s.Luminance.StdDev != 0 {
+		t.Errorf("luminance %+v, want mean 64 and no deviation", s.Luminance)
+	}
+}
@@ -0,0 +1,166 @@
+package blackbar
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// strokeOf parses the freehand stroke requested by r: the stroke form
+// value lists its points as "x,y;x,y;...", and thickness gives its width
+// in pixels (default 10). Points are clamped to bounds.
+func strokeOf(r *http.Request, bounds image.Rectangle) (pts []image.Point, thickness int, err error) {
+	fields := strings.Split(r.FormValue("stroke"), ";")
+	if len(fields) > MaxStrokePoints {
+		return nil, 0, fmt.Errorf("stroke has %d points, more than the limit of %d", len(fields), MaxStrokePoints)
+	}
+	for _, f := range fields {
+		p, err := parsePoint(f)
+		if err != nil {
+			return nil, 0, fmt.Errorf("bad stroke point: %v", err)
+		}
+		pts = append(pts, clampPoint(p, bounds))
+	}
+	thickness = 10
+	if t := r.FormValue("thickness"); t != "" {
+		if thickness, err = strconv.Atoi(t); err != nil {
+			return nil, 0, fmt.Errorf("bad stroke thickness: %v", err)
+		}
+	}
+	if thickness < 1 {
+		thickness = 1
+	}
+	if thickness > maxStrokeThickness {
+		thickness = maxStrokeThickness
+	}
+	return pts, thickness, nil
+}
+
+// maxStrokeThickness bounds the width of a stroke, in pixels.
+const maxStrokeThickness = 200
+
+// clampPoint returns the point of b nearest to p.
+func clampPoint(p image.Point, b image.Rectangle) image.Point {
+	if p.X < b.Min.X {
+		p.X = b.Min.X
+	} else if p.X >= b.Max.X {
+		p.X = b.Max.X - 1
+	}
+	if p.Y < b.Min.Y {
+		p.Y = b.Min.Y
+	} else if p.Y >= b.Max.Y {
+		p.Y = b.Max.Y - 1
+	}
+	return p
+}
+
+// drawStroke paints a polyline through pts, thickness pixels wide, with
+// round ends and joins. Each segment adds a capsule, the pixels within
+// thickness/2 of it, to a single mask, found a row at a time, so the
+// work grows with the area painted and not with the thickness times the
+// length of the stroke; the mask is then composited once.
+func drawStroke(dst *image.RGBA, pts []image.Point, thickness int, c color.Color) {
+	if len(pts) == 0 {
+		return
+	}
+	r := float64(thickness) / 2
+	var area image.Rectangle
+	for _, p := range pts {
+		area = area.Union(image.Rect(p.X, p.Y, p.X+1, p.Y+1))
+	}
+	area = area.Inset(-int(math.Ceil(r))).Intersect(dst.Bounds())
+	if area.Empty() {
+		return
+	}
+	mask := image.NewAlpha(area)
+	capsule(mask, pts[0], pts[0], r)
+	for i := 1; i < len(pts); i++ {
+		capsule(mask, pts[i-1], pts[i], r)
+	}
+	draw.DrawMask(dst, area, image.NewUniform(c), image.ZP, mask, area.Min, draw.Over)
+}
+
+// capsule sets, in mask, the pixels whose centers lie within r of the
+// segment from the center of pixel a to the center of pixel b. Each row
+// of a capsule is a single span: that of the disc at either end, and of
+// the strip between them, put together.
+func capsule(mask *image.Alpha, a, b image.Point, r float64) {
+	ax, ay := float64(a.X)+0.5, float64(a.Y)+0.5
+	bx, by := float64(b.X)+0.5, float64(b.Y)+0.5
+	dx, dy := bx-ax, by-ay
+	l2 := dx*dx + dy*dy
+	l := math.Sqrt(l2)
+	mb := mask.Bounds()
+	y0 := int(math.Floor(math.Min(ay, by) - r))
+	y1 := int(math.Ceil(math.Max(ay, by) + r))
+	if y0 < mb.Min.Y {
+		y0 = mb.Min.Y
+	}
+	if y1 > mb.Max.Y {
+		y1 = mb.Max.Y
+	}
+	for y := y0; y < y1; y++ {
+		cy := float64(y) + 0.5
+		lo, hi := math.Inf(1), math.Inf(-1)
+		span := func(x0, x1 float64) {
+			if x0 <= x1 {
+				lo, hi = math.Min(lo, x0), math.Max(hi, x1)
+			}
+		}
+		disc := func(px, py float64) {
+			if h := r*r - (cy-py)*(cy-py); h >= 0 {
+				s := math.Sqrt(h)
+				span(px-s, px+s)
+			}
+		}
+		disc(ax, ay)
+		disc(bx, by)
+		if l2 > 0 {
+			// The strip holds the points within r of the line
+			// through a and b, |(x-ax)dy - (cy-ay)dx| <= rl, that
+			// project onto the segment, 0 <= (x-ax)dx + (cy-ay)dy <= l2.
+			x0, x1 := math.Inf(-1), math.Inf(1)
+			in := true
+			clip := func(n, d, min, max float64) {
+				switch {
+				case d != 0:
+					p, q := ax+(min-n)/d, ax+(max-n)/d
+					x0, x1 = math.Max(x0, math.Min(p, q)), math.Min(x1, math.Max(p, q))
+				case n < min || n > max:
+					in = false
+				}
+			}
+			clip(-(cy-ay)*dx, dy, -r*l, r*l)
+			clip((cy-ay)*dy, dx, 0, l2)
+			if in {
+				span(x0, x1)
+			}
+		}
+		if lo > hi {
+			continue
+		}
+		// The pixels whose centers lie from lo to hi.
+		xa, xb := int(math.Ceil(lo-0.5)), int(math.Floor(hi-0.5))+1
+		if xa < mb.Min.X {
+			xa = mb.Min.X
+		}
+		if xb > mb.Max.X {
+			xb = mb.Max.X
+		}
+		for x := xa; x < xb; x++ {
+			mask.Pix[mask.PixOffset(x, y)] = 0xff
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
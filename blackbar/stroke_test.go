@@ -0,0 +1,115 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"net/url"
+	"testing"
+)
+
+// TestStroke checks that an L-shaped stroke covers both of its arms, as
+// wide as asked, and nothing far from them.
+func TestStroke(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	// Down from (20, 20) to (20, 80), then across to (80, 80).
+	v := url.Values{"stroke": {"20,20;20,80;80,80"}, "thickness": {"10"}}
+	dst, err := render(formRequest(v), &Image{}, solidImage(100, 100, white))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []image.Point{
+		{20, 20}, {20, 50}, {16, 50}, {24, 50}, {20, 80},
+		{50, 80}, {50, 76}, {50, 84}, {80, 80},
+	} {
+		if dst.RGBAAt(p.X, p.Y) == white {
+			t.Errorf("pixel %v on the stroke left unpainted", p)
+		}
+	}
+	for _, p := range []image.Point{{50, 50}, {10, 50}, {50, 90}, {90, 20}, {30, 30}} {
+		if dst.RGBAAt(p.X, p.Y) != white {
+			t.Errorf("pixel %v off the stroke painted", p)
+		}
+	}
+}
+
+func TestStrokeOf(t *testing.T) {
+	b := image.Rect(0, 0, 100, 100)
+	pts, thickness, err := strokeOf(formRequest(url.Values{"stroke": {"-5,50;150,200"}}), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []image.Point{{0, 50}, {99, 99}}; len(pts) != 2 || pts[0] != want[0] || pts[1] != want[1] {
+		t.Errorf("points %v, want %v clamped to the image", pts, want)
+	}
+	if thickness != 10 {
+		t.Errorf("thickness %d, want the default, 10", thickness)
+	}
+	for _, bad := range []url.Values{
+		{"stroke": {"1,2;3"}},
+		{"stroke": {"1,2"}, "thickness": {"wide"}},
+	} {
+		if _, _, err := strokeOf(formRequest(bad), b); err == nil {
+			t.Errorf("strokeOf(%v) succeeded, want an error", bad)
+		}
+	}
+	defer func(n int) { MaxStrokePoints = n }(MaxStrokePoints)
+	MaxStrokePoints = 2
+	if _, _, err := strokeOf(formRequest(url.Values{"stroke": {"1,1;2,2;3,3"}}), b); err == nil {
+		t.Error("stroke over MaxStrokePoints accepted")
+	}
+}
+
+// TestStrokeCapsules checks drawStroke against the pixels whose centers
+// lie within half the thickness of some segment, found one by one.
+func TestStrokeCapsules(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	near := func(p, a, b image.Point, r float64) bool {
+		px, py := float64(p.X-a.X), float64(p.Y-a.Y)
+		dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+		u := 0.0
+		if l2 := dx*dx + dy*dy; l2 > 0 {
+			u = math.Max(0, math.Min(1, (px*dx+py*dy)/l2))
+		}
+		ex, ey := px-u*dx, py-u*dy
+		return ex*ex+ey*ey <= r*r
+	}
+	for _, tt := range []struct {
+		pts       []image.Point
+		thickness int
+	}{
+		{[]image.Point{{30, 30}}, 21},
+		{[]image.Point{{10, 10}, {90, 70}}, 7},
+		{[]image.Point{{90, 5}, {5, 90}, {60, 60}}, 12},
+		{[]image.Point{{50, 0}, {50, 99}, {0, 50}, {99, 50}}, 1},
+		{[]image.Point{{0, 0}, {99, 99}}, 200},
+	} {
+		dst := solidImage(100, 100, white)
+		drawStroke(dst, tt.pts, tt.thickness, color.Black)
+		r := float64(tt.thickness) / 2
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 100; x++ {
+				want := len(tt.pts) == 1 && near(image.Pt(x, y), tt.pts[0], tt.pts[0], r)
+				for i := 1; i < len(tt.pts); i++ {
+					want = want || near(image.Pt(x, y), tt.pts[i-1], tt.pts[i], r)
+				}
+				if got := dst.RGBAAt(x, y) != white; got != want {
+					t.Fatalf("stroke %v, thickness %d: pixel (%d, %d) painted %v, want %v", tt.pts, tt.thickness, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkStrokeWorst draws the costliest stroke strokeOf allows on a
+// picture of MaxDimension: the thickest, with every point at a corner.
+func BenchmarkStrokeWorst(b *testing.B) {
+	dst := solidImage(MaxDimension, MaxDimension, color.White)
+	pts := make([]image.Point, MaxStrokePoints)
+	for i := range pts {
+		pts[i] = image.Pt((i%2)*(MaxDimension-1), (i/2%2)*(MaxDimension-1))
+	}
+	for i := 0; i < b.N; i++ {
+		drawStroke(dst, pts, maxStrokeThickness, color.Black)
+	}
+}
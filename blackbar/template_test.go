@@ -0,0 +1,60 @@
+package blackbar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"censor"
+)
+
+// TestTemplates executes every page template with a PageData filled in
+// throughout, and checks each shows what it should of it, escaped.
+func TestTemplates(t *testing.T) {
+	d := &PageData{
+		ID:     "abc",
+		Width:  640,
+		Height: 480,
+		Error:  "no <such> image",
+		CSRF:   `tok"en`,
+		Bar:    &censor.Bar{X: 12, Y: 34, S: 5},
+		Query:  "id=abc&scope=id",
+		Images: []ListEntry{{ID: "abc", Thumb: "data:image/jpeg;base64,AAAA"}, {ID: "d&f"}},
+		Cursor: "next page",
+		HEIC:   true,
+	}
+	for _, tt := range []struct {
+		name string
+		want []string
+	}{
+		{"upload.html", []string{"HEIC photos", `value="tok&#34;en"`}},
+		{"edit.html", []string{`var csrf = "tok\"en"`, `var base = "id\u003Dabc\u0026scope\u003Did"`, "var x = 12;", "var y = 34;", `value="5"`, `width="640" height="480"`, "/raw?id=abc&amp;scope=id"}},
+		{"error.html", []string{"no &lt;such&gt; image"}},
+		{"list.html", []string{`src="data:image/jpeg;base64,AAAA"`, "/edit?id=d%26f", ">d&amp;f<", "/list?cursor=next+page"}},
+	} {
+		var b bytes.Buffer
+		if err := templateSet().ExecuteTemplate(&b, tt.name, d); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(b.String(), s) {
+				t.Errorf("%s does not contain %q", tt.name, s)
+			}
+		}
+	}
+}
+
+// TestTemplatesZero checks that every page template executes with the
+// PageData of a page that concerns no image.
+func TestTemplatesZero(t *testing.T) {
+	for _, name := range templateFiles {
+		var b bytes.Buffer
+		if err := templateSet().ExecuteTemplate(&b, name, newPageData()); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if strings.Contains(b.String(), "<no value>") {
+			t.Errorf("%s shows <no value>", name)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package blackbar
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Metrics of the font drawText uses, in pixels.
+const (
+	textAdvance = 7
+	textHeight  = 13
+	textDescent = 2
+)
+
+// drawText draws s in a plain 7x13 pixel font, with the left end of its
+// baseline at dot.
+func drawText(dst draw.Image, dot image.Point, s string, c color.Color) {
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(dot.X, dot.Y),
+	}
+	d.DrawString(s)
+}
+
+// maxTextLen is the longest text, in bytes, that a bar may carry. The
+// images text is drawn on grow with it, so longer text is refused.
+const maxTextLen = 200
+
+// textOf returns the text form value of r, or an error if it is longer
+// than maxTextLen.
+func textOf(r *http.Request) (string, error) {
+	s := r.FormValue("text")
+	if len(s) > maxTextLen {
+		return "", fmt.Errorf("text is %d bytes long: want at most %d", len(s), maxTextLen)
+	}
+	return s, nil
+}
+
+// textTile returns an image just wide enough for s, and h pixels high,
+// with s drawn on it in c over bg, its baseline centered vertically as
+// far as h allows. Text beyond maxTextLen bytes is left out.
+func textTile(s string, h int, c, bg color.Color) *image.RGBA {
+	if len(s) > maxTextLen {
+		s = s[:maxTextLen]
+	}
+	m := image.NewRGBA(image.Rect(0, 0, len(s)*textAdvance, h))
+	draw.Draw(m, m.Bounds(), image.NewUniform(bg), image.ZP, draw.Src)
+	drawText(m, image.Pt(0, h-textDescent-(h-textHeight)/2), s, c)
+	return m
+}
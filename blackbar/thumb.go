@@ -0,0 +1,54 @@
+package blackbar
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+	"time"
+
+	"appengine"
+)
+
+// defaultThumbWidth is the width of thumbnails when none is asked for.
+const defaultThumbWidth = 200
+
+// thumb is the HTTP handler for small previews of stored images; it
+// handles "/thumb". It serves the image with the given id, without any
+// bars, as a JPEG w pixels wide (200 unless given), keeping the aspect
+// ratio. Images are never scaled up.
+//
+// Thumbnails may be cached for ThumbMaxAge. A page that has just changed
+// an image can add a v value to the URL, as edit does for img, to get
+// the new one.
+func thumb(w http.ResponseWriter, r *http.Request) {
+	tw := defaultThumbWidth
+	if s := r.FormValue("w"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxDimension {
+			check(badRequest(fmt.Errorf("bad width %q: must be from 1 to %d", s, MaxDimension)))
+		}
+		tw = n
+	}
+	c := appengine.NewContext(r)
+	im, _, err := fetch(c, r.FormValue("id"))
+	check(err)
+
+	etag := renditionTag(im, r)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", ThumbMaxAge/time.Second))
+	if notModified(w, r, etag) {
+		return
+	}
+	m, _, err := image.Decode(bytes.NewReader(im.Data))
+	check(err)
+	if tw < m.Bounds().Dx() {
+		m = thumbnail(m, tw)
+	}
+	var buf bytes.Buffer
+	check(jpeg.Encode(&buf, m, nil))
+	w.Header().Set("Content-type", "image/jpeg")
+	w.Header().Set("ETag", etag)
+	w.Write(buf.Bytes())
+}
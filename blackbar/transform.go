@@ -0,0 +1,90 @@
+package blackbar
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+
+	"appengine"
+)
+
+// flip is the HTTP handler for mirroring stored images; it handles
+// "/flip". The axis form value is h to mirror the image left to right,
+// or v to mirror it top to bottom. Like crop, it stores the result as a
+// new image and opens that in the editor.
+func flip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "flipping an image requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	o, ok := flips[r.FormValue("axis")]
+	if !ok {
+		check(badRequest(fmt.Errorf("bad axis %q: want h or v", r.FormValue("axis"))))
+	}
+	transformStored(w, r, o)
+}
+
+// flips maps the axes flip accepts to the EXIF orientations (see
+// upright) that mirror an image across them.
+var flips = map[string]int{"h": 2, "v": 4}
+
+// rotations maps the angles rotate turns images by, from 0 up to 360,
+// to the EXIF orientations (see upright) that turn an image clockwise by
+// them.
+var rotations = map[int]int{0: 1, 90: 6, 180: 3, 270: 8}
+
+// rotation returns the EXIF orientation that turns an image clockwise
+// by deg, a multiple of 90 degrees, positive or negative.
+func rotation(deg string) (int, error) {
+	d, err := strconv.Atoi(deg)
+	if err != nil || d%90 != 0 {
+		return 0, fmt.Errorf("bad deg %q: want a multiple of 90", deg)
+	}
+	return rotations[(d%360+360)%360], nil
+}
+
+// rotate is the HTTP handler for turning stored images; it handles
+// "/rotate". The deg form value is the angle to turn the image clockwise
+// by, a multiple of 90 degrees; negative angles turn it counterclockwise.
+// Like flip, it stores the result as a new image and opens that in the
+// editor. A whole number of turns leaves the image as it is, so the
+// editor opens on the image itself, and nothing is stored.
+func rotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "rotating an image requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	o, err := rotation(r.FormValue("deg"))
+	check(badRequest(err))
+	if o == 1 {
+		http.Redirect(w, r, editURL(r.FormValue("id")), http.StatusFound)
+		return
+	}
+	transformStored(w, r, o)
+}
+
+// transformStored applies EXIF orientation o (see upright) to the image
+// stored under the id form value of r, stores the result as a new image,
+// and redirects to the editor for it.
+func transformStored(w http.ResponseWriter, r *http.Request, o int) {
+	c := appengine.NewContext(r)
+	im, _, err := fetch(c, r.FormValue("id"))
+	check(err)
+	if im.contentType() == "image/gif" {
+		check(badRequest(errAnimated))
+	}
+	m, _, err := image.Decode(bytes.NewReader(im.Data))
+	check(err)
+	key := put(c, &Image{Exif: im.Exif, ContentType: im.ContentType}, upright(m, o), 0)
+	http.Redirect(w, r, editURL(key.StringID()), http.StatusFound)
+}
@@ -0,0 +1,114 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// cornerImage returns a w by h image, white but for a red top left
+// corner.
+func cornerImage(w, h int) *image.RGBA {
+	m := solidImage(w, h, color.White)
+	m.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	return m
+}
+
+// TestRotations checks where each rotation rotate accepts moves the
+// top left corner of a 30 by 20 image, and that it swaps width and
+// height for quarter turns.
+func TestRotations(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	for _, tt := range []struct {
+		deg    string
+		size   image.Point
+		corner image.Point
+	}{
+		{"0", image.Pt(30, 20), image.Pt(0, 0)},
+		{"90", image.Pt(20, 30), image.Pt(19, 0)},
+		{"180", image.Pt(30, 20), image.Pt(29, 19)},
+		{"270", image.Pt(20, 30), image.Pt(0, 29)},
+		{"360", image.Pt(30, 20), image.Pt(0, 0)},
+		{"-90", image.Pt(20, 30), image.Pt(0, 29)},
+		{"450", image.Pt(20, 30), image.Pt(19, 0)},
+		{"-720", image.Pt(30, 20), image.Pt(0, 0)},
+	} {
+		o, err := rotation(tt.deg)
+		if err != nil {
+			t.Errorf("deg %s: %v", tt.deg, err)
+			continue
+		}
+		m := upright(cornerImage(30, 20), o)
+		if got := m.Bounds().Size(); got != tt.size {
+			t.Errorf("deg %s: size %v, want %v", tt.deg, got, tt.size)
+			continue
+		}
+		if got := color.RGBAModel.Convert(m.At(tt.corner.X, tt.corner.Y)); got != red {
+			t.Errorf("deg %s: pixel %v is %v, want the corner, %v", tt.deg, tt.corner, got, red)
+		}
+	}
+}
+
+// TestFlips checks that each flip moves the top left corner to the
+// opposite corner across its axis, and leaves the size alone.
+func TestFlips(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	for _, tt := range []struct {
+		axis   string
+		corner image.Point
+	}{
+		{"h", image.Pt(29, 0)},
+		{"v", image.Pt(0, 19)},
+	} {
+		m := upright(cornerImage(30, 20), flips[tt.axis])
+		if got, want := m.Bounds().Size(), image.Pt(30, 20); got != want {
+			t.Errorf("axis %s: size %v, want %v", tt.axis, got, want)
+		}
+		if got := color.RGBAModel.Convert(m.At(tt.corner.X, tt.corner.Y)); got != red {
+			t.Errorf("axis %s: pixel %v is %v, want the corner, %v", tt.axis, tt.corner, got, red)
+		}
+		if got := color.RGBAModel.Convert(m.At(0, 0)); got == red {
+			t.Errorf("axis %s: corner left in place", tt.axis)
+		}
+	}
+}
+
+// TestTransformBadRequests checks that rotate and flip refuse GETs, and
+// angles and axes they do not know, and that rotate sends whole turns
+// straight back to the editor.
+func TestTransformBadRequests(t *testing.T) {
+	defer func(b bool) { CheckCSRF = b }(CheckCSRF)
+	CheckCSRF = false
+	for _, tt := range []struct {
+		fn     http.HandlerFunc
+		method string
+		body   string
+		want   int
+	}{
+		{rotate, "GET", "", http.StatusMethodNotAllowed},
+		{flip, "GET", "", http.StatusMethodNotAllowed},
+		{rotate, "POST", "id=x&deg=45", http.StatusBadRequest},
+		{rotate, "POST", "id=x&deg=-45", http.StatusBadRequest},
+		{rotate, "POST", "id=x&deg=", http.StatusBadRequest},
+		{rotate, "POST", "id=x&deg=ninety", http.StatusBadRequest},
+		// No turn at all opens the image itself, storing nothing.
+		{rotate, "POST", "id=x&deg=0", http.StatusFound},
+		{rotate, "POST", "id=x&deg=360", http.StatusFound},
+		{rotate, "POST", "id=x&deg=-360", http.StatusFound},
+		{flip, "POST", "id=x&axis=d", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		errorHandler(tt.fn)(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %q: got %d, want %d", tt.method, tt.body, w.Code, tt.want)
+		}
+		if loc := w.Header().Get("Location"); tt.want == http.StatusFound && loc != "/edit?id=x" {
+			t.Errorf("%s %q: redirected to %q, want the editor for x", tt.method, tt.body, loc)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package blackbar
+
+// Saved edits. Saving does not paint over the stored image for good:
+// the image as uploaded is kept, along with the form values of each
+// edit saved since, and the image served is rendered from the two. An
+// edit can then be undone, and the image never loses quality to being
+// encoded again and again, once per save.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+
+	"appengine"
+	"appengine/datastore"
+	"censor"
+)
+
+// maxEdits bounds the number of saved edits an image may have.
+const maxEdits = 100
+
+// transient lists the form values that say how to serve or save an
+// image, or which image, rather than how to edit it. They are not part
+// of a saved edit.
+var transient = []string{"id", "n", "v", "csrf", "fmt", "q", "icc", "trace", "exifcaption", "cursor", "from", "to", "sig", "exp", "scope"}
+
+// editOf returns the edit that r asks for.
+func editOf(r *http.Request) url.Values {
+	r.ParseForm()
+	edit := make(url.Values)
+	for k, v := range r.Form {
+		edit[k] = v
+	}
+	for _, k := range transient {
+		delete(edit, k)
+	}
+	return edit
+}
+
+// applyEdit saves edit on im, rendering im's data anew with it. Images
+// are encoded with the given quality, or 0 for the default.
+func applyEdit(im *Image, edit url.Values, quality int) error {
+	if im.contentType() == "image/gif" {
+		return badRequest(errAnimated)
+	}
+	if len(im.Edits) >= maxEdits {
+		return badRequest(fmt.Errorf("image already has %d edits, the most allowed", len(im.Edits)))
+	}
+	if im.Original == nil {
+		im.Original = im.Data
+	}
+	im.Edits = append(im.Edits, edit.Encode())
+	return im.rerender(quality)
+}
+
+// saveEdit applies edit to the image stored under id, as applyEdit does,
+// and stores the result. The image is read, edited and written in one
+// transaction, so concurrent saves and undos each build on the others
+// rather than overwrite them. It returns the image as stored.
+func saveEdit(c appengine.Context, id string, edit url.Values, quality int) (*Image, error) {
+	var saved *Image
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		im, key, err := fetch(c, id)
+		if err != nil {
+			return err
+		}
+		if err := applyEdit(im, edit, quality); err != nil {
+			return err
+		}
+		if _, err := datastore.Put(c, key, im); err != nil {
+			return err
+		}
+		saved = im
+		return nil
+	}, nil)
+	return saved, err
+}
+
+// rerender sets im's data, and its dimensions, to those of its original
+// with its edits applied, in order.
+func (im *Image) rerender(quality int) error {
+	orig, _, err := image.Decode(bytes.NewReader(im.Original))
+	if err != nil {
+		return err
+	}
+	m := censor.RGBA(orig)
+	for _, e := range im.Edits {
+		form, err := url.ParseQuery(e)
+		if err != nil {
+			return err
+		}
+		if m, err = render(&http.Request{Form: form}, im, m); err != nil {
+			return err
+		}
+	}
+	// Re-encode only the blocks the edits touched, if we can, so the
+	// rest stays exactly as uploaded. A quality given with q applies to
+	// the whole image, though, so that what is stored matches what was
+	// served.
+	var data []byte
+	ok := false
+	if quality == 0 {
+		data, ok = reencode(im.Original, orig, m)
+	}
+	if !ok {
+		var b bytes.Buffer
+		if err := encodeStored(&b, m, im.contentType(), quality); err != nil {
+			return err
+		}
+		data = b.Bytes()
+	}
+	im.Data = data
+	im.Width, im.Height = m.Bounds().Dx(), m.Bounds().Dy()
+	return nil
+}
+
+// undo is the HTTP handler for undoing the last saved edit of an image;
+// it handles "/undo". It returns to the editor afterwards.
+func undo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "undoing an edit requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkCSRF(w, r) {
+		return
+	}
+	c := appengine.NewContext(r)
+	id := r.FormValue("id")
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		im, key, err := fetch(c, id)
+		if err != nil {
+			return err
+		}
+		if len(im.Edits) == 0 {
+			return errNothingToUndo
+		}
+		im.Edits = im.Edits[:len(im.Edits)-1]
+		if len(im.Edits) == 0 {
+			im.Data, im.Original = im.Original, nil
+			b := image.ZR
+			if cfg, _, err := image.DecodeConfig(bytes.NewReader(im.Data)); err == nil {
+				b = image.Rect(0, 0, cfg.Width, cfg.Height)
+			}
+			im.Width, im.Height = b.Dx(), b.Dy()
+		} else if err := im.rerender(0); err != nil {
+			return err
+		}
+		_, err = datastore.Put(c, key, im)
+		return err
+	}, nil)
+	if err == errNothingToUndo {
+		check(badRequest(err))
+	}
+	check(err)
+	forget(c, id)
+	http.Redirect(w, r, editURL(id), http.StatusFound)
+}
+
+// errNothingToUndo reports that an image has no saved edits.
+var errNothingToUndo = errors.New("there is nothing to undo")
@@ -0,0 +1,89 @@
+package blackbar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/url"
+	"testing"
+)
+
+// TestApplyEdit checks, over a few seeded images, that applying an edit
+// paints it and keeps the image as uploaded, that edits accumulate, and
+// that animations are refused.
+func TestApplyEdit(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	var gifData bytes.Buffer
+	if err := gif.EncodeAll(&gifData, testAnimation(2, 20, 20)); err != nil {
+		t.Fatal(err)
+	}
+	images := map[string]*Image{
+		"png":  {ContentType: "image/png", Data: encodePNG(t, solidImage(200, 100, white))},
+		"jpeg": {Data: encodeJPEG(t, solidImage(200, 100, white))},
+		"gif":  {ContentType: "image/gif", Data: gifData.Bytes()},
+	}
+	first := url.Values{"x": {"50"}, "y": {"50"}, "s": {"0"}}
+	second := url.Values{"x": {"150"}, "y": {"50"}, "s": {"0"}}
+	for name, im := range images {
+		uploaded := im.Data
+		err := applyEdit(im, first, 0)
+		if name == "gif" {
+			if err == nil {
+				t.Error("gif: edit applied to an animation")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if err := applyEdit(im, second, 0); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(im.Original, uploaded) {
+			t.Errorf("%s: image as uploaded not kept", name)
+		}
+		if len(im.Edits) != 2 || im.Edits[0] != first.Encode() || im.Edits[1] != second.Encode() {
+			t.Errorf("%s: edits %q, want %q and %q", name, im.Edits, first.Encode(), second.Encode())
+		}
+		m, _, err := image.Decode(bytes.NewReader(im.Data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		for _, p := range []image.Point{{50, 50}, {150, 50}} {
+			if r, _, _, _ := m.At(p.X, p.Y).RGBA(); r>>8 > 0x20 {
+				t.Errorf("%s: pixel %v under a bar has red %#x, want near black", name, p, r>>8)
+			}
+		}
+		if r, _, _, _ := m.At(100, 10).RGBA(); r>>8 < 0xe0 {
+			t.Errorf("%s: pixel (100, 10) away from the bars has red %#x, want near white", name, r>>8)
+		}
+		if im.Width != 200 || im.Height != 100 {
+			t.Errorf("%s: size %dx%d, want 200x100", name, im.Width, im.Height)
+		}
+	}
+}
+
+// encodeJPEG returns m encoded as JPEG.
+func encodeJPEG(t *testing.T, m image.Image) []byte {
+	var b bytes.Buffer
+	if err := encodeStored(&b, m, "image/jpeg", 0); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// TestEditOf checks that the values saying how to serve an image are
+// left out of the edit saved.
+func TestEditOf(t *testing.T) {
+	r := formRequest(url.Values{
+		"id": {"abc"}, "x": {"10"}, "y": {"20"}, "n": {"1"}, "fmt": {"png"},
+		"sig": {"s"}, "exp": {"1"}, "scope": {"id"}, "csrf": {"t"}, "v": {"2"},
+	})
+	if got, want := editOf(r).Encode(), "x=10&y=20"; got != want {
+		t.Errorf("editOf = %s, want %s", got, want)
+	}
+}
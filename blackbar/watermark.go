@@ -0,0 +1,24 @@
+package blackbar
+
+import (
+	"image"
+	"image/color"
+)
+
+// watermark tiles text across m in staggered rows, drawn in white at
+// the given opacity out of 255. At low opacity the text is barely
+// visible but survives in every part of a copy, including crops.
+func watermark(m *image.RGBA, text string, opacity uint8) {
+	if text == "" || opacity == 0 {
+		return
+	}
+	c := color.NRGBA{255, 255, 255, opacity}
+	b := m.Bounds()
+	step := (len(text) + 4) * textAdvance
+	for row, y := 0, b.Min.Y+textHeight; y < b.Max.Y+textHeight; row, y = row+1, y+3*textHeight {
+		x := b.Min.X - step/2*(row%2)
+		for ; x < b.Max.X; x += step {
+			drawText(m, image.Pt(x, y-textDescent), text, c)
+		}
+	}
+}
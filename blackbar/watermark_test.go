@@ -0,0 +1,57 @@
+package blackbar
+
+import (
+	"image/color"
+	"testing"
+)
+
+// TestWatermark checks that a traced copy carries the id across the
+// image, changed by no more than the opacity allows.
+func TestWatermark(t *testing.T) {
+	const opacity = 16
+	gray := color.RGBA{0x40, 0x40, 0x40, 0xff}
+	m := solidImage(300, 200, gray)
+	watermark(m, "abc123", opacity)
+
+	marked, maxDiff := 0, 0
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			c := m.RGBAAt(x, y)
+			if c != gray {
+				marked++
+			}
+			if d := int(c.R) - int(gray.R); d > maxDiff {
+				maxDiff = d
+			}
+		}
+	}
+	if marked < 300*200/50 {
+		t.Errorf("only %d pixels marked, want the id all over", marked)
+	}
+	// White at 16/255 over 0x40 lightens it by (0xff-0x40)*16/255, 12.
+	if maxDiff == 0 || maxDiff > 13 {
+		t.Errorf("marks lighten pixels by up to %d, want 1 to 13", maxDiff)
+	}
+	// Every quarter carries some of it.
+	for _, q := range [][2]int{{0, 0}, {150, 0}, {0, 100}, {150, 100}} {
+		n := 0
+		for y := q[1]; y < q[1]+100; y++ {
+			for x := q[0]; x < q[0]+150; x++ {
+				if m.RGBAAt(x, y) != gray {
+					n++
+				}
+			}
+		}
+		if n == 0 {
+			t.Errorf("quarter at %v unmarked", q)
+		}
+	}
+
+	m = solidImage(30, 20, gray)
+	watermark(m, "abc123", 0)
+	for i, p := range m.Pix {
+		if p != gray.R && p != 0xff {
+			t.Fatalf("byte %d changed at opacity 0", i)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build webp
+// +build webp
+
+package blackbar
+
+// WebP encoding, like AVIF, runs a C library through a WebAssembly
+// runtime, so it is only compiled in when building with -tags webp.
+// Uploads in WebP are decoded too.
+
+import (
+	"image"
+	"io"
+
+	"github.com/gen2brain/webp"
+	_ "golang.org/x/image/webp"
+)
+
+func init() {
+	encodeWebP = func(w io.Writer, m image.Image, quality int) error {
+		if quality == 0 {
+			quality = webp.DefaultQuality
+		}
+		return webp.Encode(w, m, webp.Options{
+			Quality: quality,
+			Method:  webp.DefaultMethod,
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package blackbar
+
+// Live previews over a WebSocket (RFC 6455). The editor asks for a new
+// rendering with every move of the bar, and over plain HTTP each request
+// reads the image from the datastore and decodes it again. A socket
+// keeps the decoded image for as long as the editor is open.
+//
+// Only what the previews need of the protocol is implemented: unframed
+// messages of modest size, ping and close.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"appengine"
+	"censor"
+)
+
+// wsGUID is the key the handshake hashes with the client's nonce.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWSMessage bounds the size of a message from the client.
+const maxWSMessage = 4096
+
+// Opcodes of WebSocket frames.
+const (
+	wsText   = 0x1
+	wsBinary = 0x2
+	wsClose  = 0x8
+	wsPing   = 0x9
+	wsPong   = 0xA
+)
+
+// liveEdit is the HTTP handler for live previews; it handles "/ws". The
+// image is named by the id form value of the request opening the socket.
+// Each text message then received is a query string of the form values
+// img accepts, such as "x=100&y=80&s=3", and is answered with a binary
+// message holding the rendering, or a text message saying what was wrong
+// with the request. Saving is not possible over the socket. If img URLs
+// must be signed, so must the one opening the socket.
+func liveEdit(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, "a WebSocket handshake is required", http.StatusBadRequest)
+		return
+	}
+	if !checkSignature(w, r) {
+		return
+	}
+	c := appengine.NewContext(r)
+	im, _, err := fetch(c, r.FormValue("id"))
+	check(err)
+	if im.contentType() == "image/gif" {
+		check(badRequest(errors.New("animated images cannot be previewed live")))
+	}
+	m, _, err := image.Decode(bytes.NewReader(im.Data))
+	check(err)
+	base := censor.RGBA(m)
+	defer censor.Release(base) // kept only while the socket is open
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets are not supported by this server", http.StatusNotImplemented)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	check(err)
+	defer conn.Close()
+	h := sha1.New()
+	io.WriteString(h, r.Header.Get("Sec-WebSocket-Key")+wsGUID)
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	if rw.Flush() != nil {
+		return
+	}
+
+	for {
+		op, msg, err := readFrame(rw.Reader)
+		if err != nil {
+			c.Infof("ws: %v", err)
+			writeFrame(rw.Writer, wsClose, nil)
+			return
+		}
+		switch op {
+		case wsPing:
+			err = writeFrame(rw.Writer, wsPong, msg)
+		case wsClose:
+			writeFrame(rw.Writer, wsClose, nil)
+			return
+		case wsText:
+			out, rerr := renderLive(im, base, string(msg))
+			if rerr != nil {
+				err = writeFrame(rw.Writer, wsText, []byte(rerr.Error()))
+			} else {
+				err = writeFrame(rw.Writer, wsBinary, out)
+			}
+		}
+		if err != nil {
+			c.Infof("ws: %v", err)
+			return
+		}
+	}
+}
+
+// renderLive renders the decoded image base of im as the query string q
+// asks, and returns it encoded. base itself is left as it is.
+func renderLive(im *Image, base *image.RGBA, q string) ([]byte, error) {
+	form, err := url.ParseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if form.Get("n") != "" {
+		return nil, errors.New("saving is not possible over the socket")
+	}
+	r := &http.Request{Form: form}
+	b := base.Bounds()
+	m := image.NewRGBA(b)
+	draw.Draw(m, b, base, b.Min, draw.Src)
+	dst, err := render(r, im, m)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	o := outputOf(r)
+	if o.Format == "" && im.contentType() == "image/png" {
+		o.Format = "png" // serve as stored
+	}
+	_, err = o.encode(&buf, dst)
+	return buf.Bytes(), err
+}
+
+// readFrame reads a frame sent by the client, which must be masked, and
+// returns its opcode and unmasked payload.
+func readFrame(r *bufio.Reader) (op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	if hdr[0]&0x80 == 0 || hdr[0]&0x0f == 0 {
+		return 0, nil, errors.New("fragmented messages are not supported")
+	}
+	if hdr[1]&0x80 == 0 {
+		return 0, nil, errors.New("frame from client is not masked")
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var l uint16
+		err = binary.Read(r, binary.BigEndian, &l)
+		n = uint64(l)
+	case 127:
+		err = binary.Read(r, binary.BigEndian, &n)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if n > maxWSMessage {
+		return 0, nil, fmt.Errorf("message of %d bytes is over the limit of %d", n, maxWSMessage)
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return hdr[0] & 0x0f, payload, nil
+}
+
+// writeFrame writes payload to the client as a single, unmasked frame
+// with opcode op, and flushes it.
+func writeFrame(w *bufio.Writer, op byte, payload []byte) error {
+	w.WriteByte(0x80 | op)
+	switch n := len(payload); {
+	case n < 126:
+		w.WriteByte(byte(n))
+	case n <= 0xffff:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(n))
+	}
+	w.Write(payload)
+	return w.Flush()
+}
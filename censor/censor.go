@@ -0,0 +1,246 @@
+// Package censor paints over parts of images: with bars of a color or
+// pattern, or by blurring or pixelating them. It is the core of the
+// blackbar service, and depends on nothing but the standard library and
+// the resize package.
+package censor
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+)
+
+// Bar is a single blackbar centered on (X, Y). S is the size step
+// picked on the edit page; the bar is (S+1)*50 by (S+1)*10 pixels.
+// If Feather is positive, the bar fades in over that many pixels at its
+// edges rather than ending sharply. A is an angle in degrees to rotate
+// the bar by, clockwise about its center. A bar turned by a multiple of
+// 90° still lines up with the axes, and is painted as the rectangle Rect
+// gives; feathering applies only to such bars. Thickness is the width of
+// the border an Outlined bar is drawn as; see Paint.
+type Bar struct {
+	X         int     `json:"x"`
+	Y         int     `json:"y"`
+	S         int     `json:"s"`
+	Feather   int     `json:"feather,omitempty"`
+	A         float64 `json:"a,omitempty"`
+	Thickness int     `json:"thickness,omitempty"`
+}
+
+// Rect returns the rectangle covered by the bar, before any rotation
+// other than by a multiple of 90°; a bar turned by an odd multiple of
+// 90° stands on end. A negative S counts as 0, the smallest bar.
+func (b Bar) Rect() image.Rectangle {
+	s := b.S
+	if s < 0 {
+		s = 0
+	}
+	dp := image.Pt(b.X, b.Y)
+	size := image.Pt((s+1)*50, (s+1)*10)
+	if math.Mod(math.Abs(b.A), 180) == 90 {
+		size.X, size.Y = size.Y, size.X
+	}
+	return image.Rectangle{dp.Sub(size.Div(2)), dp.Add(size.Div(2))}
+}
+
+// tilted reports whether the bar is rotated other than by a multiple of
+// 90°, so that its edges do not line up with the axes.
+func (b Bar) tilted() bool {
+	return math.Mod(b.A, 90) != 0
+}
+
+// Bounds returns a rectangle holding all that the bar covers: Rect, or,
+// if the bar is tilted, a square around the circle through its corners.
+func (b Bar) Bounds() image.Rectangle {
+	r := b.Rect()
+	if !b.tilted() {
+		return r
+	}
+	reach := int(math.Ceil(math.Hypot(float64(r.Dx()/2), float64(r.Dy()/2)))) + 1
+	return image.Rect(b.X-reach, b.Y-reach, b.X+reach, b.Y+reach)
+}
+
+// A Mode is a way of censoring what is under a bar.
+type Mode string
+
+// Modes.
+const (
+	Solid     Mode = "bar"      // paint the bar with its fill; the default
+	Blurred   Mode = "blur"     // blur what is under the bar
+	Pixelated Mode = "pixelate" // turn what is under the bar into a mosaic
+	Outlined  Mode = "outline"  // draw only the border of the bar, to highlight
+)
+
+// defaultThickness is the width of an Outlined bar's border when the
+// bar does not give one.
+const defaultThickness = 2
+
+// Paint censors the areas under bars in an RGBA version of m, in the
+// given mode (Solid unless Blurred, Pixelated or Outlined), and returns
+// it. If m is already RGBA it is painted on directly. Solid and Outlined
+// bars are painted using fill (usually a uniform color, otherwise an
+// image in the same coordinate space as m) as the source; other modes
+// ignore it. Outlined bars leave what is inside the border untouched,
+// and are never feathered; like blurred and pixelated bars, they heed
+// an angle only if it is a multiple of 90°.
+func Paint(m image.Image, bars []Bar, fill image.Image, mode Mode) *image.RGBA {
+	dst := RGBA(m)
+	for _, b := range bars {
+		if b.S < 0 {
+			b.S = 0 // as Rect has it
+		}
+		if mode == Blurred || mode == Pixelated || mode == Outlined {
+			if b.X > 0 {
+				paintRect(dst, b.Rect(), fill, mode, b.S+1, b.Thickness)
+			}
+			continue
+		}
+		if b.X > 0 { // only draw if coordinates provided
+			r := b.Rect()
+			if b.tilted() {
+				mask, mr := rotatedMask(b)
+				draw.DrawMask(dst, mr, fill, mr.Min, mask, mr.Min, draw.Over)
+			} else if b.Feather > 0 {
+				draw.DrawMask(dst, r, fill, r.Min, featherMask(r, b.Feather), r.Min, draw.Over)
+			} else {
+				// Over, so that a translucent color tints the image.
+				draw.Draw(dst, r, fill, r.Min, draw.Over)
+			}
+		}
+	}
+	return dst
+}
+
+// PaintRect censors the area r of m in the given mode, as Paint does an
+// unrotated bar covering r. Blurring and pixelating are as strong as for
+// a bar as tall as the shorter side of r.
+func PaintRect(m *image.RGBA, r image.Rectangle, fill image.Image, mode Mode) {
+	level := r.Dx()
+	if r.Dy() < level {
+		level = r.Dy()
+	}
+	level /= 10 // as a bar's height is 10 per size step
+	if level < 1 {
+		level = 1
+	}
+	paintRect(m, r, fill, mode, level, 0)
+}
+
+// paintRect censors r in dst in the given mode, with the strength of a
+// bar of size step level-1. An Outlined border is t pixels wide.
+func paintRect(dst *image.RGBA, r image.Rectangle, fill image.Image, mode Mode, level, t int) {
+	switch mode {
+	case Blurred:
+		// Three passes of a box blur come close to a Gaussian.
+		for i := 0; i < 3; i++ {
+			BoxBlur(dst, r, level*2)
+		}
+	case Pixelated:
+		Pixelate(dst, r, level*4)
+	case Outlined:
+		outline(dst, r, t, fill)
+	default:
+		draw.Draw(dst, r, fill, r.Min, draw.Over)
+	}
+}
+
+// outline draws the border of r, t pixels wide (defaultThickness if t
+// is not positive), onto dst using fill as the source. The four sides
+// do not overlap, so a translucent fill tints the corners no more than
+// the rest.
+func outline(dst *image.RGBA, r image.Rectangle, t int, fill image.Image) {
+	if t <= 0 {
+		t = defaultThickness
+	}
+	if 2*t > r.Dx() || 2*t > r.Dy() {
+		draw.Draw(dst, r, fill, r.Min, draw.Over) // all border
+		return
+	}
+	for _, side := range []image.Rectangle{
+		{r.Min, image.Pt(r.Max.X, r.Min.Y+t)},                          // top
+		{image.Pt(r.Min.X, r.Max.Y-t), r.Max},                          // bottom
+		{image.Pt(r.Min.X, r.Min.Y+t), image.Pt(r.Min.X+t, r.Max.Y-t)}, // left
+		{image.Pt(r.Max.X-t, r.Min.Y+t), image.Pt(r.Max.X, r.Max.Y-t)}, // right
+	} {
+		draw.Draw(dst, side, fill, side.Min, draw.Over)
+	}
+}
+
+// rotatedMask returns an alpha mask that is opaque over the bar b
+// rotated by its angle, and the bounds of that mask.
+func rotatedMask(b Bar) (*image.Alpha, image.Rectangle) {
+	r := b.Rect()
+	hw, hh := float64(r.Dx()/2), float64(r.Dy()/2)
+	sin, cos := math.Sincos(b.A * math.Pi / 180)
+	bounds := b.Bounds()
+	mask := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// Rotate the pixel's center back into the bar's frame.
+			dx, dy := float64(x-b.X)+0.5, float64(y-b.Y)+0.5
+			u, v := dx*cos+dy*sin, -dx*sin+dy*cos
+			if -hw <= u && u < hw && -hh <= v && v < hh {
+				mask.Pix[mask.PixOffset(x, y)] = 0xff
+			}
+		}
+	}
+	return mask, bounds
+}
+
+// featherMask returns an alpha mask over r that is opaque in the middle
+// and ramps down towards the edges over the outermost n pixels.
+func featherMask(r image.Rectangle, n int) *image.Alpha {
+	mask := image.NewAlpha(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// d is the distance to the nearest edge.
+			d := x - r.Min.X
+			for _, e := range []int{r.Max.X - 1 - x, y - r.Min.Y, r.Max.Y - 1 - y} {
+				if e < d {
+					d = e
+				}
+			}
+			a := 255
+			if d < n {
+				a = (d + 1) * 255 / (n + 1)
+			}
+			mask.Pix[mask.PixOffset(x, y)] = uint8(a)
+		}
+	}
+	return mask
+}
+
+// RGBA returns an RGBA version of the image, making a copy only if
+// necessary. Copies draw their pixels from pixPool.
+func RGBA(m image.Image) *image.RGBA {
+	if r, ok := m.(*image.RGBA); ok {
+		return r
+	}
+	b := m.Bounds()
+	r := &image.RGBA{Pix: pooledPix(4 * b.Dx() * b.Dy()), Stride: 4 * b.Dx(), Rect: b}
+	draw.Draw(r, b, m, b.Min, draw.Src) // overwrites every pixel
+	return r
+}
+
+// pixPool holds pixel buffers of images that are no longer needed, so
+// that rendering one image after another, as an editor does, reuses
+// them rather than leaving each to the garbage collector.
+var pixPool sync.Pool
+
+// pooledPix returns a buffer of n bytes, from pixPool if one there is
+// large enough. Its contents are unspecified; the caller must overwrite
+// all of it.
+func pooledPix(n int) []uint8 {
+	if p, ok := pixPool.Get().([]uint8); ok && cap(p) >= n {
+		return p[:n]
+	}
+	return make([]uint8, n)
+}
+
+// Release returns the pixels of m to the pool RGBA draws from, for
+// reuse. Neither m nor any image sharing its pixels may be used
+// afterwards.
+func Release(m *image.RGBA) {
+	pixPool.Put(m.Pix[:0])
+}
@@ -0,0 +1,131 @@
+package censor
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// gray returns a w by h image filled with mid gray.
+func gray(w, h int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(m, m.Bounds(), image.NewUniform(color.RGBA{0x80, 0x80, 0x80, 0xff}), image.ZP, draw.Src)
+	return m
+}
+
+// TestPaintTranslucent checks that a translucent fill dims the whole
+// bar evenly, its center included, rather than covering it.
+func TestPaintTranslucent(t *testing.T) {
+	half := color.RGBA{0, 0, 0, 0x80} // black at 50%, premultiplied
+	for _, b := range []Bar{
+		{X: 100, Y: 50, S: 1},
+		{X: 100, Y: 50, S: 1, A: 30},
+		{X: 100, Y: 50, S: 1, Feather: 3},
+	} {
+		dst := Paint(gray(200, 100), []Bar{b}, image.NewUniform(half), Solid)
+		// Half of mid gray, give or take rounding.
+		for _, p := range []image.Point{{100, 50}, {99, 49}, {110, 52}} {
+			if got := dst.RGBAAt(p.X, p.Y); got.R < 0x3f || got.R > 0x40 || got.A != 0xff {
+				t.Errorf("bar %+v: pixel %v is %v, want about {64 64 64 255}", b, p, got)
+			}
+		}
+	}
+}
+
+// TestPaintOpaque checks that opaque fills cover the bar exactly, and
+// nothing else.
+func TestPaintOpaque(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 0xff}
+	dst := Paint(gray(200, 100), []Bar{{X: 100, Y: 50, S: 1}}, image.NewUniform(black), Solid)
+	r := image.Rect(50, 40, 150, 60)
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			in := image.Pt(x, y).In(r)
+			if got := dst.RGBAAt(x, y); (got == black) != in {
+				t.Fatalf("pixel (%d, %d) is %v; in bar: %v", x, y, got, in)
+			}
+		}
+	}
+}
+
+// TestPaintNoPosition checks that a bar without a position paints
+// nothing at all.
+func TestPaintNoPosition(t *testing.T) {
+	orig := gray(200, 100)
+	dst := Paint(gray(200, 100), []Bar{{X: 0, Y: 50, S: 1}}, image.NewUniform(color.Black), Solid)
+	if string(dst.Pix) != string(orig.Pix) {
+		t.Error("bar without a position painted")
+	}
+}
+
+// TestPaintFeather checks that a feathered bar is opaque in the middle
+// and fades toward its edges, reaching no further than an unfeathered
+// one.
+func TestPaintFeather(t *testing.T) {
+	white := image.NewUniform(color.White)
+	black := image.NewUniform(color.Black)
+	m := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(m, m.Bounds(), white, image.ZP, draw.Src)
+	// The bar is 100 by 20, from (50, 40) to (150, 60).
+	dst := Paint(m, []Bar{{X: 100, Y: 50, S: 1, Feather: 5}}, black, Solid)
+	if got := dst.RGBAAt(100, 50); got.R != 0 {
+		t.Errorf("center is %v, want black", got)
+	}
+	edge, inner := dst.RGBAAt(50, 50), dst.RGBAAt(52, 50)
+	if edge.R == 0 || edge.R == 0xff {
+		t.Errorf("edge pixel is %v, want partly painted", edge)
+	}
+	if inner.R >= edge.R {
+		t.Errorf("pixel 2 in (%v) no darker than the edge (%v)", inner, edge)
+	}
+	if got := dst.RGBAAt(100, 40); got.R == 0 || got.R == 0xff {
+		t.Errorf("top edge pixel is %v, want partly painted", got)
+	}
+	for _, p := range []image.Point{{49, 50}, {150, 50}, {100, 39}, {100, 60}} {
+		if got := dst.RGBAAt(p.X, p.Y); got.R != 0xff {
+			t.Errorf("pixel %v outside the bar is %v", p, got)
+		}
+	}
+}
+
+// TestRGBAReuse checks that a copy made with a released buffer holds
+// exactly the image, whatever the buffer held before.
+func TestRGBAReuse(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	want := image.NewRGBA(src.Bounds())
+	draw.Draw(want, want.Bounds(), src, image.ZP, draw.Src)
+	for i := 0; i < 3; i++ {
+		dirty := RGBA(image.NewGray(src.Bounds()))
+		for j := range dirty.Pix {
+			dirty.Pix[j] = 0xff
+		}
+		Release(dirty)
+		got := RGBA(src)
+		if string(got.Pix) != string(want.Pix) {
+			t.Fatal("copy into a reused buffer differs from the image")
+		}
+		Release(got)
+	}
+}
+
+// benchmarkRender paints a bar on a copy of a photo-sized image, as img
+// does, releasing the copy afterwards if release is set.
+func benchmarkRender(b *testing.B, release bool) {
+	src := image.NewYCbCr(image.Rect(0, 0, 1024, 768), image.YCbCrSubsampleRatio420)
+	fill := image.NewUniform(color.Black)
+	bars := []Bar{{X: 500, Y: 300, S: 5}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst := Paint(src, bars, fill, Solid)
+		if release {
+			Release(dst)
+		}
+	}
+}
+
+func BenchmarkRenderNoPool(b *testing.B) { benchmarkRender(b, false) }
+func BenchmarkRenderPooled(b *testing.B) { benchmarkRender(b, true) }
@@ -0,0 +1,69 @@
+package censor
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// BoxBlur blurs the part of m within r, averaging each pixel with its
+// neighbors up to radius pixels away, first across and then down. Only
+// pixels within r are read, so the blur does not bleed in from outside.
+func BoxBlur(m *image.RGBA, r image.Rectangle, radius int) {
+	r = r.Intersect(m.Bounds())
+	if radius < 1 || r.Empty() {
+		return
+	}
+	blur1D := func(n int, at func(i int) []uint8) {
+		sums := make([][4]int, n+1)
+		for i := 0; i < n; i++ {
+			p := at(i)
+			for c := 0; c < 4; c++ {
+				sums[i+1][c] = sums[i][c] + int(p[c])
+			}
+		}
+		for i := 0; i < n; i++ {
+			lo, hi := i-radius, i+radius+1
+			if lo < 0 {
+				lo = 0
+			}
+			if hi > n {
+				hi = n
+			}
+			p := at(i)
+			for c := 0; c < 4; c++ {
+				p[c] = uint8((sums[hi][c] - sums[lo][c]) / (hi - lo))
+			}
+		}
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		blur1D(r.Dx(), func(i int) []uint8 { return m.Pix[m.PixOffset(r.Min.X+i, y):] })
+	}
+	for x := r.Min.X; x < r.Max.X; x++ {
+		blur1D(r.Dy(), func(i int) []uint8 { return m.Pix[m.PixOffset(x, r.Min.Y+i):] })
+	}
+}
+
+// Pixelate turns the part of m within r into a mosaic of size by size
+// pixel blocks, each the average color of the pixels it replaces.
+// Blocks at the edges of r, or of m, are clipped.
+func Pixelate(m *image.RGBA, r image.Rectangle, size int) {
+	r = r.Intersect(m.Bounds())
+	for by := r.Min.Y; by < r.Max.Y; by += size {
+		for bx := r.Min.X; bx < r.Max.X; bx += size {
+			block := image.Rect(bx, by, bx+size, by+size).Intersect(r)
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				p := m.Pix[m.PixOffset(block.Min.X, y):m.PixOffset(block.Max.X, y)]
+				for i := 0; i < len(p); i += 4 {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(p[i+c])
+					}
+				}
+			}
+			n := block.Dx() * block.Dy()
+			avg := color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)}
+			draw.Draw(m, block, image.NewUniform(avg), image.ZP, draw.Src)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package censor
+
+import (
+	"image"
+
+	"resize"
+)
+
+// FitWithin returns the largest dimensions with the aspect ratio of b
+// that fit within maxW by maxH. Neither is ever less than 1, however
+// extreme the ratio.
+func FitWithin(b image.Rectangle, maxW, maxH int) (w, h int) {
+	w, h = maxW, maxH
+	if dx, dy := b.Dx(), b.Dy(); dx > 0 && dy > 0 {
+		if dx*maxH > dy*maxW {
+			h = dy * maxW / dx
+		} else {
+			w = dx * maxH / dy
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// A Scaling is a way of shrinking an image.
+type Scaling string
+
+// Scalings.
+const (
+	Adaptive Scaling = ""         // sample large images, then smooth; the default
+	Sampled  Scaling = "resample" // only sample, which keeps the edges of line art crisp
+	Smoothed Scaling = "smooth"   // only smooth, which suits photos best but is slowest
+)
+
+// Shrink returns m scaled down, if need be, for efficient painting. An
+// image larger than max pixels in either dimension is squeezed to fit
+// within half that, using the given scaling.
+//
+// Adaptive scaling downsamples an image over twice max to max first,
+// which is quicker, and the resize that follows smooths out the
+// roughness; smaller images are only resized.
+func Shrink(m image.Image, max int, how Scaling) image.Image {
+	b := m.Bounds()
+	if b.Dx() <= max && b.Dy() <= max {
+		return m
+	}
+	switch how {
+	case Sampled:
+		w, h := FitWithin(b, max/2, max/2)
+		return resize.Resample(m, b, w, h)
+	case Adaptive:
+		if b.Dx() > 2*max || b.Dy() > 2*max {
+			w, h := FitWithin(b, max, max)
+			m = resize.Resample(m, b, w, h)
+			b = m.Bounds()
+		}
+	}
+	w, h := FitWithin(b, max/2, max/2)
+	return resize.Resize(m, b, w, h)
+}
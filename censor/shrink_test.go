@@ -0,0 +1,51 @@
+package censor
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFitWithin(t *testing.T) {
+	for _, tt := range []struct {
+		b          image.Rectangle
+		maxW, maxH int
+		w, h       int
+	}{
+		{image.Rect(0, 0, 200, 100), 100, 100, 100, 50},
+		{image.Rect(0, 0, 100, 200), 100, 100, 50, 100},
+		{image.Rect(0, 0, 50, 50), 100, 100, 100, 100},
+		{image.Rect(0, 0, 1, 10000), 100, 100, 1, 100},
+		{image.Rect(0, 0, 10000, 1), 100, 100, 100, 1},
+		{image.Rect(0, 0, 1, 1000000), 512, 512, 1, 512},
+		{image.Rect(0, 0, 1000000, 1), 512, 512, 512, 1},
+		{image.Rect(0, 0, 3, 10000), 100, 100, 1, 100},
+		{image.Rect(0, 0, 0, 0), 100, 100, 100, 100},
+		{image.Rect(0, 0, 200, 100), 400, 100, 200, 100},
+		{image.Rect(0, 0, 200, 100), 100, 400, 100, 50},
+		{image.Rect(0, 0, 300, 300), 640, 480, 480, 480},
+		{image.Rect(50, 20, 250, 120), 100, 100, 100, 50},
+		{image.Rect(-100, -100, 100, 0), 100, 100, 100, 50},
+	} {
+		if w, h := FitWithin(tt.b, tt.maxW, tt.maxH); w != tt.w || h != tt.h {
+			t.Errorf("FitWithin(%v, %d, %d) = %d, %d, want %d, %d", tt.b, tt.maxW, tt.maxH, w, h, tt.w, tt.h)
+		}
+	}
+}
+
+// TestShrinkExtreme checks that Shrink copes with images one pixel wide
+// or high, in every scaling, and keeps them within half of max.
+func TestShrinkExtreme(t *testing.T) {
+	const max = 100
+	for _, size := range []image.Point{{1, 1000}, {1000, 1}, {1, 10000}, {10000, 1}, {2, 250}} {
+		for _, how := range []Scaling{Adaptive, Sampled, Smoothed} {
+			m := Shrink(image.NewRGBA(image.Rectangle{Max: size}), max, how)
+			got := m.Bounds().Size()
+			if got.X < 1 || got.Y < 1 || got.X > max/2 || got.Y > max/2 {
+				t.Errorf("Shrink(%v, %d, %q) is %v, want within %d by %d", size, max, how, got, max/2, max/2)
+			}
+			if (size.X > size.Y) != (got.X > got.Y) {
+				t.Errorf("Shrink(%v, %d, %q) is %v, which turns it", size, max, how, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Command blackbar paints blackbars onto a local image file, the way the
+// blackbar service does for uploads.
+//
+// Usage:
+//
+//	blackbar -bar x,y,s [-bar x,y,s ...] [-mode bar|blur|pixelate|outline] -o out.jpg in
+//
+// The input may be a JPEG, PNG or GIF; the output is encoded as PNG or
+// JPEG according to the extension of -o. Images larger than -max pixels
+// are shrunk first, as on upload.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"censor"
+)
+
+// barList is a flag.Value collecting the bars given by repeated -bar
+// flags.
+type barList []censor.Bar
+
+func (l *barList) String() string {
+	return fmt.Sprint(*l)
+}
+
+// Set parses a bar written as "x,y,s".
+func (l *barList) Set(s string) error {
+	f := strings.Split(s, ",")
+	if len(f) != 3 {
+		return fmt.Errorf("%q is not of the form x,y,s", s)
+	}
+	var n [3]int
+	for i := range f {
+		v, err := strconv.Atoi(strings.TrimSpace(f[i]))
+		if err != nil {
+			return err
+		}
+		n[i] = v
+	}
+	*l = append(*l, censor.Bar{X: n[0], Y: n[1], S: n[2]})
+	return nil
+}
+
+var (
+	bars    barList
+	out     = flag.String("o", "", "output `file`; .jpg, .jpeg or .png")
+	max     = flag.Int("max", 1200, "shrink images larger than this many pixels")
+	scaling = flag.String("scaling", "", "how large images are shrunk: resample, smooth, or empty to choose by size")
+	mode    = flag.String("mode", string(censor.Solid), "how bars are painted: bar, blur, pixelate or outline")
+)
+
+func init() {
+	flag.Var(&bars, "bar", "a bar as `x,y,s`; may be repeated")
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("blackbar: ")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: blackbar -bar x,y,s [flags] -o output input\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *out == "" || len(bars) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch censor.Mode(*mode) {
+	case censor.Solid, censor.Blurred, censor.Pixelated, censor.Outlined:
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
+	switch censor.Scaling(*scaling) {
+	case censor.Adaptive, censor.Sampled, censor.Smoothed:
+	default:
+		log.Fatalf("unknown scaling %q", *scaling)
+	}
+	encode, err := encoderFor(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+	m = censor.Shrink(m, *max, censor.Scaling(*scaling))
+	dst := censor.Paint(m, bars, image.NewUniform(color.Black), censor.Mode(*mode))
+
+	w, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := encode(w, dst); err != nil {
+		w.Close()
+		log.Fatalf("%s: %v", *out, err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// encoderFor returns the encoder for the image format named by the
+// extension of file.
+func encoderFor(file string) (func(*os.File, image.Image) error, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".png":
+		return func(w *os.File, m image.Image) error { return png.Encode(w, m) }, nil
+	case ".jpg", ".jpeg":
+		return func(w *os.File, m image.Image) error { return jpeg.Encode(w, m, nil) }, nil
+	}
+	return nil, fmt.Errorf("%s: output must end in .jpg, .jpeg or .png", file)
+}
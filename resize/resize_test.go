@@ -0,0 +1,43 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestExtremeAspect checks that Resize and Resample scale images of
+// extreme proportions, down to one pixel wide or high, to the size asked
+// for, with their color intact.
+func TestExtremeAspect(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	for _, tt := range []struct {
+		size image.Point
+		w, h int
+	}{
+		{image.Pt(1, 1000), 1, 50},
+		{image.Pt(1000, 1), 50, 1},
+		{image.Pt(1, 10), 1, 100},
+		{image.Pt(10, 1), 100, 1},
+		{image.Pt(5000, 100), 600, 12},
+		{image.Pt(100, 5000), 12, 600},
+	} {
+		m := image.NewRGBA(image.Rectangle{Max: tt.size})
+		for i := 0; i < len(m.Pix); i += 4 {
+			m.Pix[i], m.Pix[i+3] = 0xff, 0xff
+		}
+		for _, f := range []struct {
+			name string
+			fn   func(image.Image, image.Rectangle, int, int) image.Image
+		}{{"Resize", Resize}, {"Resample", Resample}} {
+			dst := f.fn(m, m.Bounds(), tt.w, tt.h)
+			if got, want := dst.Bounds(), image.Rect(0, 0, tt.w, tt.h); got != want {
+				t.Errorf("%s(%v, %d, %d) is %v, want %v", f.name, tt.size, tt.w, tt.h, got, want)
+				continue
+			}
+			if got := color.RGBAModel.Convert(dst.At(tt.w-1, tt.h-1)); got != red {
+				t.Errorf("%s(%v, %d, %d): last pixel is %v, want %v", f.name, tt.size, tt.w, tt.h, got, red)
+			}
+		}
+	}
+}